@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -33,9 +34,13 @@ func GetInfoFromFileName(fileName string) (BuildInfo, error) {
 
 	if strings.Contains(fileName, "debugsymbols") {
 		info.Options.Debug = true
+		// debug symbol archives have the same name as the
+		// corresponding build, with an additional component,
+		// which would otherwise confuse the target parsing.
+		fileName = strings.Replace(fileName, "-debugsymbols", "", 1)
 	}
 
-	for _, arch := range []MongoDBArch{AMD64, X86, POWER, ZSeries} {
+	for _, arch := range []MongoDBArch{AMD64, X86, POWER, ZSeries, ARM64, MacOSARM64} {
 		if strings.Contains(fileName, string(arch)) {
 			info.Options.Arch = arch
 			break
@@ -67,13 +72,17 @@ func GetInfoFromFileName(fileName string) (BuildInfo, error) {
 	return info, nil
 }
 
+// releaseCandidate matches the version suffix of release candidates,
+// which must not match architectures like "aarch64".
+var releaseCandidate = regexp.MustCompile(`-rc\d+`)
+
 func getVersion(fn string, edition MongoDBEdition) (string, error) {
 	parts := strings.Split(fn, "-")
 	if len(parts) <= 3 {
 		return "", errors.Errorf("path '%s' must have at least 3 dash-separated parts", fn)
 	}
 
-	if strings.Contains(fn, "rc") {
+	if releaseCandidate.MatchString(fn) {
 		return strings.Join(parts[len(parts)-2:], "-"), nil
 	} else if strings.Contains(fn, "latest") {
 		if isArch(parts[len(parts)-2]) {
@@ -130,7 +139,7 @@ func getTarget(fn string) (string, error) {
 		var target string
 		parts := strings.Split(fn, "-")
 
-		if strings.Contains(fn, "latest") || strings.Contains(fn, "rc") {
+		if strings.Contains(fn, "latest") || releaseCandidate.MatchString(fn) {
 			target = parts[len(parts)-3]
 		} else {
 			target = parts[len(parts)-2]
@@ -179,5 +188,5 @@ func getTarget(fn string) (string, error) {
 func isArch(part string) bool {
 	arch := MongoDBArch(part)
 
-	return arch == AMD64 || arch == ZSeries || arch == POWER || arch == X86
+	return arch == AMD64 || arch == ZSeries || arch == POWER || arch == X86 || arch == ARM64 || arch == MacOSARM64
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTargetIdentification(t *testing.T) {
@@ -341,3 +342,49 @@ func TestVersionIdentification(t *testing.T) {
 		assert.Error(err)
 	}
 }
+
+func TestArchIdentification(t *testing.T) {
+	for name, expected := range map[string]BuildInfo{
+		"mongodb-linux-aarch64-enterprise-rhel82-6.0.0": {
+			Version: "6.0.0",
+			Options: BuildOptions{Target: "rhel82", Arch: ARM64, Edition: Enterprise},
+		},
+		"mongodb-macos-arm64-6.0.0": {
+			Version: "6.0.0",
+			Options: BuildOptions{Target: "macos", Arch: MacOSARM64, Edition: Base},
+		},
+		"mongodb-linux-aarch64-ubuntu2004-6.0.0-rc1": {
+			Version: "6.0.0-rc1",
+			Options: BuildOptions{Target: "ubuntu2004", Arch: ARM64, Edition: CommunityTargeted},
+		},
+	} {
+		info, err := GetInfoFromFileName(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, info, name)
+	}
+}
+
+func TestDebugSymbolsIdentification(t *testing.T) {
+	assert := assert.New(t)
+
+	builds := map[string]BuildInfo{
+		"mongodb-linux-x86_64-debugsymbols-3.2.11": {
+			Version: "3.2.11",
+			Options: BuildOptions{Target: "linux", Arch: AMD64, Edition: Base, Debug: true},
+		},
+		"mongodb-linux-x86_64-enterprise-rhel70-debugsymbols-3.4.0-rc5": {
+			Version: "3.4.0-rc5",
+			Options: BuildOptions{Target: "rhel70", Arch: AMD64, Edition: Enterprise, Debug: true},
+		},
+		"mongodb-linux-x86_64-ubuntu1604-debugsymbols-3.4.0": {
+			Version: "3.4.0",
+			Options: BuildOptions{Target: "ubuntu1604", Arch: AMD64, Edition: CommunityTargeted, Debug: true},
+		},
+	}
+
+	for fn, expected := range builds {
+		info, err := GetInfoFromFileName(fn)
+		assert.NoError(err, fn)
+		assert.Equal(expected, info, fn)
+	}
+}
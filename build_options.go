@@ -40,5 +40,51 @@ func (o BuildOptions) Validate() error {
 	catcher.NewWhen(o.Arch == "", "must specify an arch")
 	catcher.NewWhen(o.Edition == "", "must specify an edition")
 
-	return catcher.Resolve()
+	return ResolveInvalidOptions(catcher)
+}
+
+// BuildMatrix describes the cross product of several targets,
+// architectures and editions.
+type BuildMatrix struct {
+	Targets  []string         `json:"targets"`
+	Arches   []MongoDBArch    `json:"arches"`
+	Editions []MongoDBEdition `json:"editions"`
+	Debug    bool             `json:"debug"`
+}
+
+// IsZero returns true if the matrix does not specify any builds.
+func (m BuildMatrix) IsZero() bool {
+	return len(m.Targets) == 0 && len(m.Arches) == 0 && len(m.Editions) == 0
+}
+
+// Expand returns the build options for every combination of target,
+// architecture and edition, ordered by target, then architecture,
+// then edition.
+func (m BuildMatrix) Expand() []BuildOptions {
+	out := make([]BuildOptions, 0, len(m.Targets)*len(m.Arches)*len(m.Editions))
+	for _, target := range m.Targets {
+		for _, arch := range m.Arches {
+			for _, edition := range m.Editions {
+				out = append(out, BuildOptions{
+					Target:  target,
+					Arch:    arch,
+					Edition: edition,
+					Debug:   m.Debug,
+				})
+			}
+		}
+	}
+	return out
+}
+
+// Validate checks that the matrix specifies at least one value for
+// each dimension.
+func (m BuildMatrix) Validate() error {
+	catcher := grip.NewBasicCatcher()
+
+	catcher.NewWhen(len(m.Targets) == 0, "must specify at least one target")
+	catcher.NewWhen(len(m.Arches) == 0, "must specify at least one arch")
+	catcher.NewWhen(len(m.Editions) == 0, "must specify at least one edition")
+
+	return ResolveInvalidOptions(catcher)
 }
@@ -40,3 +40,26 @@ func TestBuildOptionsValidation(t *testing.T) {
 	assert.Exactly(info.Options, opts)
 	assert.Exactly("bar", info.Version)
 }
+
+func TestBuildMatrix(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(BuildMatrix{}.IsZero())
+	assert.Error(BuildMatrix{}.Validate())
+	assert.Error(BuildMatrix{Targets: []string{"rhel80"}, Arches: []MongoDBArch{AMD64}}.Validate())
+
+	m := BuildMatrix{
+		Targets:  []string{"rhel80", "ubuntu2204"},
+		Arches:   []MongoDBArch{AMD64},
+		Editions: []MongoDBEdition{Enterprise, CommunityTargeted},
+		Debug:    true,
+	}
+	assert.False(m.IsZero())
+	assert.NoError(m.Validate())
+	assert.Equal([]BuildOptions{
+		{Target: "rhel80", Arch: AMD64, Edition: Enterprise, Debug: true},
+		{Target: "rhel80", Arch: AMD64, Edition: CommunityTargeted, Debug: true},
+		{Target: "ubuntu2204", Arch: AMD64, Edition: Enterprise, Debug: true},
+		{Target: "ubuntu2204", Arch: AMD64, Edition: CommunityTargeted, Debug: true},
+	}, m.Expand())
+}
@@ -20,36 +20,110 @@ import (
 // artifacts managed by bond, and provides an interface for retrieving
 // artifacts.
 type BuildCatalog struct {
-	Path  string
-	table map[BuildInfo]string
-	feed  *ArtifactsFeed
-	mutex sync.RWMutex
+	Path     string
+	table    map[BuildInfo]string
+	metadata map[BuildInfo]BuildMetadata
+	feed     *ArtifactsFeed
+	mutex    sync.RWMutex
 }
 
 // NewCatalog populates and returns a BuildCatalog object from a given path.
 func NewCatalog(ctx context.Context, path string) (*BuildCatalog, error) {
+	catalog, _, err := NewCatalogWithOptions(ctx, path, CatalogOptions{})
+	return catalog, err
+}
+
+// CatalogOptions control how a BuildCatalog handles directories that
+// look like builds but cannot be added to the catalog.
+type CatalogOptions struct {
+	// SkipInvalid, when true, causes catalog construction to skip
+	// invalid builds and report them rather than failing.
+	SkipInvalid bool
+	// InvalidAction determines what happens to directories that are
+	// skipped. This only has an effect when SkipInvalid is true.
+	InvalidAction InvalidBuildAction
+	// QuarantinePath is the directory that invalid builds are moved
+	// into when InvalidAction is QuarantineInvalidBuilds. Defaults
+	// to a ".quarantine" directory within the catalog's path.
+	QuarantinePath string
+}
+
+// InvalidBuildAction describes how a catalog handles invalid builds
+// that it skips.
+type InvalidBuildAction string
+
+// Specific values for InvalidBuildAction.
+const (
+	IgnoreInvalidBuilds     InvalidBuildAction = ""
+	QuarantineInvalidBuilds InvalidBuildAction = "quarantine"
+	RemoveInvalidBuilds     InvalidBuildAction = "remove"
+)
+
+// Validate checks that the options are internally consistent.
+func (o CatalogOptions) Validate() error {
+	switch o.InvalidAction {
+	case IgnoreInvalidBuilds, QuarantineInvalidBuilds, RemoveInvalidBuilds:
+	default:
+		return errors.Wrapf(ErrInvalidOptions, "invalid build action '%s' is not supported", o.InvalidAction)
+	}
+
+	if o.InvalidAction != IgnoreInvalidBuilds && !o.SkipInvalid {
+		return errors.Wrap(ErrInvalidOptions, "cannot quarantine or remove invalid builds unless skipping invalid builds")
+	}
+
+	return nil
+}
+
+// SkippedBuild describes a directory that was not added to a
+// BuildCatalog, and the reason why.
+type SkippedBuild struct {
+	Path   string `bson:"path" json:"path" yaml:"path"`
+	Reason string `bson:"reason" json:"reason" yaml:"reason"`
+	// MovedTo is the location of the build if it was quarantined.
+	MovedTo string `bson:"moved_to,omitempty" json:"moved_to,omitempty" yaml:"moved_to,omitempty"`
+	// Removed is true if the build was deleted.
+	Removed bool `bson:"removed" json:"removed" yaml:"removed"`
+	// Duplicate is true if the build is valid, but the catalog
+	// already has a build with the same version and options.
+	// Duplicates are left in place.
+	Duplicate bool `bson:"duplicate" json:"duplicate" yaml:"duplicate"`
+}
+
+// NewCatalogWithOptions populates and returns a BuildCatalog object
+// from a given path. When the SkipInvalid option is set, builds that
+// cannot be added to the catalog do not cause an error, but are
+// returned alongside the catalog, and invalid builds are quarantined
+// or removed as specified by the options. Duplicate builds are
+// reported but left in place.
+func NewCatalogWithOptions(ctx context.Context, path string, opts CatalogOptions) (*BuildCatalog, []SkippedBuild, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid catalog options")
+	}
+
 	var err error
 	path, err = filepath.Abs(path)
 	if err != nil {
-		return nil, errors.Wrap(err, "resolving absolute path")
+		return nil, nil, errors.Wrap(err, "resolving absolute path")
 	}
 
 	contents, err := getContents(path)
 	if err != nil {
-		return nil, errors.Wrap(err, "finding content")
+		return nil, nil, errors.Wrap(err, "finding content")
 	}
 
 	feed, err := GetArtifactsFeed(ctx, path)
 	if err != nil {
-		return nil, errors.Wrap(err, "finding build feed")
+		return nil, nil, errors.Wrap(err, "finding build feed")
 	}
 
 	cache := &BuildCatalog{
-		Path:  path,
-		feed:  feed,
-		table: map[BuildInfo]string{},
+		Path:     path,
+		feed:     feed,
+		table:    map[BuildInfo]string{},
+		metadata: map[BuildInfo]BuildMetadata{},
 	}
 
+	skipped := []SkippedBuild{}
 	catcher := grip.NewCatcher()
 	for _, obj := range contents {
 		if !obj.IsDir() {
@@ -63,16 +137,79 @@ func NewCatalog(ctx context.Context, path string) (*BuildCatalog, error) {
 		fileName := filepath.Join(path, obj.Name())
 
 		if err := cache.Add(fileName); err != nil {
+			if !opts.SkipInvalid {
+				catcher.Add(err)
+				continue
+			}
+
+			// duplicates are valid builds, so they are reported
+			// but never quarantined or removed.
+			if errors.Is(err, ErrDuplicateBuild) {
+				skipped = append(skipped, SkippedBuild{Path: fileName, Reason: err.Error(), Duplicate: true})
+				grip.Warning(ctx, message.Fields{
+					"message": "skipped duplicate build",
+					"path":    fileName,
+					"reason":  err.Error(),
+				})
+				continue
+			}
+
+			skip, err := handleInvalidBuild(fileName, err, opts)
 			catcher.Add(err)
+			skipped = append(skipped, skip)
+
+			grip.Warning(ctx, message.Fields{
+				"message": "skipped invalid build",
+				"path":    skip.Path,
+				"reason":  skip.Reason,
+				"moved":   skip.MovedTo,
+				"removed": skip.Removed,
+			})
 			continue
 		}
 	}
 
 	if catcher.HasErrors() {
-		return nil, errors.Wrapf(catcher.Resolve(), "building build catalog from path '%s'", path)
+		return nil, nil, errors.Wrapf(catcher.Resolve(), "building build catalog from path '%s'", path)
 	}
 
-	return cache, nil
+	return cache, skipped, nil
+}
+
+func handleInvalidBuild(fileName string, reason error, opts CatalogOptions) (SkippedBuild, error) {
+	skip := SkippedBuild{
+		Path:   fileName,
+		Reason: reason.Error(),
+	}
+
+	switch opts.InvalidAction {
+	case QuarantineInvalidBuilds:
+		dir := opts.QuarantinePath
+		if dir == "" {
+			dir = filepath.Join(filepath.Dir(fileName), ".quarantine")
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return skip, errors.Wrapf(err, "creating quarantine directory '%s'", dir)
+		}
+
+		dest := filepath.Join(dir, filepath.Base(fileName))
+		if err := os.RemoveAll(dest); err != nil {
+			return skip, errors.Wrapf(err, "removing previously quarantined build '%s'", dest)
+		}
+
+		if err := os.Rename(fileName, dest); err != nil {
+			return skip, errors.Wrapf(err, "quarantining build '%s'", fileName)
+		}
+		skip.MovedTo = dest
+	case RemoveInvalidBuilds:
+		if err := os.RemoveAll(fileName); err != nil {
+			return skip, errors.Wrapf(err, "removing invalid build '%s'", fileName)
+		}
+		skip.Removed = true
+	}
+
+	return skip, nil
 }
 
 // Add adds a build to the catalog, and returns an error if it's not a
@@ -95,22 +232,54 @@ func (c *BuildCatalog) Add(fileName string) error {
 		return errors.Wrap(err, "collecting information about build")
 	}
 
-	err = validateBuildArtifacts(fileName, info.Version)
+	if info.Options.Debug {
+		err = validateDebugSymbols(fileName, info.Version)
+	} else {
+		err = validateBuildArtifacts(fileName, info.Version)
+	}
 	if err != nil {
 		return errors.Wrapf(err, "validating contents of file '%s'", fileName)
 	}
 
+	md := c.getBuildMetadata(fileName, info)
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	if _, ok := c.table[info]; ok {
-		return errors.Errorf("path '%s' exists in cache", fileName)
+	if existing, ok := c.table[info]; ok {
+		return errors.Wrapf(ErrDuplicateBuild, "path '%s' is the same build as '%s', which is in the cache", fileName, existing)
 	}
 
 	c.table[info] = fileName
+	if !md.IsZero() {
+		c.metadata[info] = md
+	}
 
 	return nil
 }
 
+// getBuildMetadata prefers the metadata recorded when the build was
+// extracted, and falls back to the feed's record of the version.
+func (c *BuildCatalog) getBuildMetadata(fileName string, info BuildInfo) BuildMetadata {
+	if md, err := ReadBuildMetadata(fileName); err == nil {
+		return md
+	}
+
+	if c.feed == nil {
+		return BuildMetadata{}
+	}
+
+	version, ok := c.feed.GetVersion(info.Version)
+	if !ok || version.GitHash == "" {
+		return BuildMetadata{}
+	}
+
+	return BuildMetadata{
+		Version: version.Version,
+		GitHash: version.GitHash,
+		Source:  MetadataSourceFeed,
+	}
+}
+
 // Contents returns a copy of the contents of the catalog.
 func (c *BuildCatalog) Contents() map[BuildInfo]string {
 	output := map[BuildInfo]string{}
@@ -124,6 +293,21 @@ func (c *BuildCatalog) Contents() map[BuildInfo]string {
 	return output
 }
 
+// Metadata returns a copy of the version and git hash recorded for
+// each build in the catalog. For nightly ("latest") builds, this is
+// the version of the snapshot that was downloaded.
+func (c *BuildCatalog) Metadata() map[BuildInfo]BuildMetadata {
+	output := map[BuildInfo]BuildMetadata{}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for k, v := range c.metadata {
+		output[k] = v
+	}
+
+	return output
+}
+
 func (c *BuildCatalog) String() string {
 	inverted := map[string]BuildInfo{}
 
@@ -147,12 +331,144 @@ func (c *BuildCatalog) String() string {
 
 // Get returns the path to a build in the BuildCatalog based on the
 // parameters presented. Returns an error if a build matching the
-// parameters specified does not exist in the cache.
+// parameters specified does not exist in the cache. When debug is
+// true, Get returns the path to the debug symbols for the build. The
+// target and arch may be "auto" to use those of the current host.
 func (c *BuildCatalog) Get(version, edition, target, arch string, debug bool) (string, error) {
+	info, err := c.resolveBuildInfo(version, edition, target, arch, debug)
+	if err != nil {
+		return "", err
+	}
+
+	// TODO consider if we want to validate against bad or invalid
+	// options; potentially by extending the buildinfo validation method.
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	path, ok := c.table[info]
+	if !ok {
+		return "", errors.Wrapf(ErrBuildNotFound, "could not find version '%s', edition '%s', target '%s', arch '%s' in path '%s'",
+			info.Version, edition, info.Options.Target, arch, c.Path)
+	}
+
+	return path, nil
+}
+
+// GetDebugSymbols returns the path to the debug symbols associated
+// with a build in the catalog. Returns an error if either the build
+// or its debug symbols are not in the catalog.
+func (c *BuildCatalog) GetDebugSymbols(version, edition, target, arch string) (string, error) {
+	info, err := c.resolveBuildInfo(version, edition, target, arch, false)
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if _, ok := c.table[info]; !ok {
+		return "", errors.Wrapf(ErrBuildNotFound, "could not find version '%s', edition '%s', target '%s', arch '%s' in path '%s'",
+			info.Version, edition, info.Options.Target, arch, c.Path)
+	}
+
+	info.Options.Debug = true
+	path, ok := c.table[info]
+	if !ok {
+		return "", errors.Wrapf(ErrBuildNotFound, "no debug symbols for version '%s', edition '%s', target '%s', arch '%s' in path '%s'",
+			info.Version, edition, info.Options.Target, arch, c.Path)
+	}
+
+	return path, nil
+}
+
+// GetBuildMetadata returns the version and git hash recorded for a
+// build in the catalog.
+func (c *BuildCatalog) GetBuildMetadata(version, edition, target, arch string) (BuildMetadata, error) {
+	info, err := c.resolveBuildInfo(version, edition, target, arch, false)
+	if err != nil {
+		return BuildMetadata{}, err
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if _, ok := c.table[info]; !ok {
+		return BuildMetadata{}, errors.Wrapf(ErrBuildNotFound, "could not find version '%s', edition '%s', target '%s', arch '%s' in path '%s'",
+			info.Version, edition, info.Options.Target, arch, c.Path)
+	}
+
+	md, ok := c.metadata[info]
+	if !ok {
+		return BuildMetadata{}, errors.Wrapf(ErrBuildNotFound, "no git hash is known for version '%s', edition '%s', target '%s', arch '%s'",
+			info.Version, edition, info.Options.Target, arch)
+	}
+
+	return md, nil
+}
+
+// GetByGitHash returns the path to a build in the BuildCatalog built
+// from the specified commit, which may be a full git hash or a unique
+// prefix. Builds are matched using the git hash recorded when the
+// build was extracted, or, failing that, the feed. Hashes recorded
+// from the names of nightly archives are abbreviated, and match full
+// hashes that they are a prefix of.
+func (c *BuildCatalog) GetByGitHash(hash, edition, target, arch string, debug bool) (string, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if hash == "" {
+		return "", errors.New("must specify a git hash")
+	}
+
+	// the version is only used to resolve automatic targets.
+	info, err := c.resolveBuildInfo("", edition, target, arch, debug)
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var (
+		path    string
+		matched BuildMetadata
+	)
+	for bi, md := range c.metadata {
+		if bi.Options != info.Options || !gitHashMatches(md.GitHash, hash) {
+			continue
+		}
+
+		if path != "" && !strings.EqualFold(matched.GitHash, md.GitHash) {
+			return "", errors.Errorf("git hash '%s' is ambiguous, matching at least '%s' and '%s'",
+				hash, matched.GitHash, md.GitHash)
+		}
+
+		path = c.table[bi]
+		matched = md
+	}
+
+	if path == "" {
+		return "", errors.Wrapf(ErrBuildNotFound, "could not find git hash '%s', edition '%s', target '%s', arch '%s' in path '%s'",
+			hash, edition, info.Options.Target, arch, c.Path)
+	}
+
+	return path, nil
+}
+
+// gitHashMatches returns true if the hash is a prefix of the recorded
+// hash, or if the recorded hash is an abbreviation of the hash.
+func gitHashMatches(recorded, hash string) bool {
+	recorded = strings.ToLower(recorded)
+	if recorded == "" {
+		return false
+	}
+	return strings.HasPrefix(recorded, hash) || strings.HasPrefix(hash, recorded)
+}
+
+func (c *BuildCatalog) resolveBuildInfo(version, edition, target, arch string, debug bool) (BuildInfo, error) {
 	if strings.Contains(version, "current") {
 		v, err := c.feed.GetLatestRelease(version)
 		if err != nil {
-			return "", errors.Wrapf(err, "determining current stable release for series '%s'", version)
+			return BuildInfo{}, errors.Wrapf(err, "determining current stable release for series '%s'", version)
 		}
 
 		version = v.Version
@@ -166,14 +482,16 @@ func (c *BuildCatalog) Get(version, edition, target, arch string, debug bool) (s
 			// For OSX, the target depends on the version. Before 4.1, OSX
 			// targets are "osx". However, starting in 4.1.1, OSX targets are
 			// "macos".
-			if runtime.GOOS == "darwin" {
+			if runtime.GOOS == "darwin" && version == "" {
+				target = "macos"
+			} else if runtime.GOOS == "darwin" {
 				parsedVersion, err := CreateMongoDBVersion(version)
 				if err != nil {
-					return "", errors.Wrap(err, "parsing version")
+					return BuildInfo{}, errors.Wrap(err, "parsing version")
 				}
 				macosVersion, err := CreateMongoDBVersion("4.1.1")
 				if err != nil {
-					return "", errors.Wrap(err, "parsing comparison version")
+					return BuildInfo{}, errors.Wrap(err, "parsing comparison version")
 				}
 				if parsedVersion.IsGreaterThanOrEqualTo(macosVersion) {
 					target = "macos"
@@ -190,7 +508,15 @@ func (c *BuildCatalog) Get(version, edition, target, arch string, debug bool) (s
 		}
 	}
 
-	info := BuildInfo{
+	if arch == "auto" {
+		a, err := GetPlatformArch(runtime.GOOS, runtime.GOARCH)
+		if err != nil {
+			return BuildInfo{}, errors.Wrap(err, "determining architecture")
+		}
+		arch = string(a)
+	}
+
+	return BuildInfo{
 		Version: version,
 		Options: BuildOptions{
 			Target:  target,
@@ -198,21 +524,7 @@ func (c *BuildCatalog) Get(version, edition, target, arch string, debug bool) (s
 			Edition: MongoDBEdition(edition),
 			Debug:   debug,
 		},
-	}
-
-	// TODO consider if we want to validate against bad or invalid
-	// options; potentially by extending the buildinfo validation method.
-
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-
-	path, ok := c.table[info]
-	if !ok {
-		return "", errors.Errorf("could not find version '%s', edition '%s', target '%s', arch '%s' in path '%s'",
-			version, edition, target, arch, c.Path)
-	}
-
-	return path, nil
+	}, nil
 }
 
 func getContents(path string) ([]os.FileInfo, error) {
@@ -258,3 +570,33 @@ func validateBuildArtifacts(path, version string) error {
 
 	return catcher.Resolve()
 }
+
+// validateDebugSymbols checks that an extracted debug symbols archive
+// contains symbols for mongod. Symbol layouts differ by platform:
+// linux uses "mongod.debug", windows uses "mongod.pdb", and macOS
+// uses a "mongod.dSYM" bundle.
+func validateDebugSymbols(path, version string) error {
+	found := false
+	err := filepath.Walk(path, func(fn string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		switch info.Name() {
+		case "mongod.debug", "mongod.pdb", "mongod.dSYM":
+			found = true
+			return filepath.SkipAll
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "finding debug symbols for version '%s'", version)
+	}
+
+	if !found {
+		return errors.Errorf("debug symbols for mongod are missing from path '%s' for version '%s'", path, version)
+	}
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package bond
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type CatalogSuite struct {
+	dir     string
+	require *require.Assertions
+	suite.Suite
+}
+
+func TestCatalogSuite(t *testing.T) {
+	suite.Run(t, new(CatalogSuite))
+}
+
+func (s *CatalogSuite) SetupTest() {
+	var err error
+	s.require = s.Require()
+	s.dir, err = ioutil.TempDir("", "bond-catalog")
+	s.require.NoError(err)
+
+	// a freshly written feed prevents the catalog from attempting to
+	// download a new one.
+	s.require.NoError(ioutil.WriteFile(filepath.Join(s.dir, "full.json"), []byte(`{"versions": []}`), 0644))
+
+	s.makeBuild("mongodb-linux-x86_64-3.2.11", true)
+	s.makeBuild("mongodb-linux-x86_64-3.2.12", false)
+	s.makeBuild("mongodb-unknown", true)
+}
+
+func (s *CatalogSuite) TearDownTest() {
+	s.NoError(os.RemoveAll(s.dir))
+}
+
+func (s *CatalogSuite) makeBuild(name string, valid bool) {
+	bin := filepath.Join(s.dir, name, "bin")
+	s.require.NoError(os.MkdirAll(bin, 0755))
+	if !valid {
+		return
+	}
+
+	for _, fn := range []string{"mongod", "mongos"} {
+		if runtime.GOOS == "windows" {
+			fn += ".exe"
+		}
+		s.require.NoError(ioutil.WriteFile(filepath.Join(bin, fn), []byte("binary"), 0755))
+	}
+}
+
+func (s *CatalogSuite) TestStrictCatalogFailsWithInvalidBuilds() {
+	catalog, err := NewCatalog(context.Background(), s.dir)
+	s.Error(err)
+	s.Nil(catalog)
+}
+
+func (s *CatalogSuite) TestSkippingInvalidBuildsLeavesThemInPlace() {
+	catalog, skipped, err := NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{SkipInvalid: true})
+	s.require.NoError(err)
+	s.require.NotNil(catalog)
+	s.Len(catalog.Contents(), 1)
+	s.Len(skipped, 2)
+
+	for _, skip := range skipped {
+		s.NotEmpty(skip.Reason)
+		s.Empty(skip.MovedTo)
+		s.False(skip.Removed)
+		s.DirExists(skip.Path)
+	}
+
+	path, err := catalog.Get("3.2.11", string(Base), "linux", string(AMD64), false)
+	s.NoError(err)
+	s.Equal(filepath.Join(s.dir, "mongodb-linux-x86_64-3.2.11"), path)
+}
+
+func (s *CatalogSuite) TestQuarantiningInvalidBuilds() {
+	catalog, skipped, err := NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{
+		SkipInvalid:   true,
+		InvalidAction: QuarantineInvalidBuilds,
+	})
+	s.require.NoError(err)
+	s.require.NotNil(catalog)
+	s.Len(skipped, 2)
+
+	for _, skip := range skipped {
+		s.NoDirExists(skip.Path)
+		s.DirExists(skip.MovedTo)
+		s.Equal(filepath.Join(s.dir, ".quarantine", filepath.Base(skip.Path)), skip.MovedTo)
+	}
+
+	// quarantined builds are not considered by subsequent catalogs.
+	catalog, err = NewCatalog(context.Background(), s.dir)
+	s.NoError(err)
+	s.Len(catalog.Contents(), 1)
+}
+
+func (s *CatalogSuite) TestRemovingInvalidBuilds() {
+	catalog, skipped, err := NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{
+		SkipInvalid:   true,
+		InvalidAction: RemoveInvalidBuilds,
+	})
+	s.require.NoError(err)
+	s.require.NotNil(catalog)
+	s.Len(skipped, 2)
+
+	for _, skip := range skipped {
+		s.True(skip.Removed)
+		s.NoDirExists(skip.Path)
+	}
+}
+
+func (s *CatalogSuite) TestDuplicateBuildsAreNotRemoved() {
+	s.makeBuild("mongodb-linux-x86_64-enterprise-ubuntu1604-3.4.0", true)
+	s.makeBuild("mongodb-linux-x86_64-enterprise-ubuntu1604-copy-3.4.0", true)
+
+	catalog, skipped, err := NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{
+		SkipInvalid:   true,
+		InvalidAction: RemoveInvalidBuilds,
+	})
+	s.require.NoError(err)
+	s.require.NotNil(catalog)
+	s.Len(catalog.Contents(), 2)
+	s.require.Len(skipped, 3)
+
+	var duplicates int
+	for _, skip := range skipped {
+		if !skip.Duplicate {
+			s.True(skip.Removed)
+			continue
+		}
+
+		duplicates++
+		s.Equal(filepath.Join(s.dir, "mongodb-linux-x86_64-enterprise-ubuntu1604-copy-3.4.0"), skip.Path)
+		s.Contains(skip.Reason, "mongodb-linux-x86_64-enterprise-ubuntu1604-3.4.0")
+		s.False(skip.Removed)
+		s.Empty(skip.MovedTo)
+		s.DirExists(skip.Path)
+	}
+	s.Equal(1, duplicates)
+
+	err = catalog.Add(filepath.Join(s.dir, "mongodb-linux-x86_64-enterprise-ubuntu1604-3.4.0"))
+	s.True(errors.Is(err, ErrDuplicateBuild))
+}
+
+func (s *CatalogSuite) TestInvalidOptions() {
+	_, _, err := NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{InvalidAction: RemoveInvalidBuilds})
+	s.Error(err)
+
+	_, _, err = NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{SkipInvalid: true, InvalidAction: "archive"})
+	s.Error(err)
+}
+
+func (s *CatalogSuite) TestDebugSymbolsAreAttachedToBuilds() {
+	symbols := filepath.Join(s.dir, "mongodb-linux-x86_64-debugsymbols-3.2.11")
+	s.require.NoError(os.MkdirAll(symbols, 0755))
+	s.require.NoError(ioutil.WriteFile(filepath.Join(symbols, "mongod.debug"), []byte("symbols"), 0644))
+
+	// symbols without a matching build are still cataloged.
+	orphan := filepath.Join(s.dir, "mongodb-linux-x86_64-debugsymbols-3.2.12")
+	s.require.NoError(os.MkdirAll(orphan, 0755))
+	s.require.NoError(ioutil.WriteFile(filepath.Join(orphan, "mongod.debug"), []byte("symbols"), 0644))
+
+	// symbols directories without symbols for mongod are invalid.
+	s.require.NoError(os.MkdirAll(filepath.Join(s.dir, "mongodb-linux-x86_64-debugsymbols-3.2.13"), 0755))
+
+	catalog, skipped, err := NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{SkipInvalid: true})
+	s.require.NoError(err)
+	s.Len(skipped, 3)
+	s.Len(catalog.Contents(), 3)
+
+	path, err := catalog.GetDebugSymbols("3.2.11", string(Base), "linux", string(AMD64))
+	s.NoError(err)
+	s.Equal(symbols, path)
+
+	path, err = catalog.Get("3.2.11", string(Base), "linux", string(AMD64), true)
+	s.NoError(err)
+	s.Equal(symbols, path)
+
+	_, err = catalog.GetDebugSymbols("3.2.12", string(Base), "linux", string(AMD64))
+	s.Error(err)
+}
+
+func (s *CatalogSuite) TestErrors() {
+	s.require.NoError(ioutil.WriteFile(filepath.Join(s.dir, "full.json"), []byte(testFeedData), 0644))
+	s.makeBuild("mongodb-linux-x86_64-4.4.0", true)
+
+	catalog, _, err := NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{SkipInvalid: true})
+	s.require.NoError(err)
+
+	_, err = catalog.Get("4.4.1", string(Base), "linux", string(AMD64), false)
+	s.True(errors.Is(err, ErrBuildNotFound), "%v", err)
+	_, err = catalog.GetDebugSymbols("4.4.0", string(Base), "linux", string(AMD64))
+	s.True(errors.Is(err, ErrBuildNotFound), "%v", err)
+	_, err = catalog.GetBuildMetadata("4.4.1", string(Base), "linux", string(AMD64))
+	s.True(errors.Is(err, ErrBuildNotFound), "%v", err)
+	_, err = catalog.GetByGitHash("ffffffff", string(Base), "linux", string(AMD64), false)
+	s.True(errors.Is(err, ErrBuildNotFound), "%v", err)
+	_, err = catalog.Get("3.2-current", string(Base), "linux", string(AMD64), false)
+	s.True(errors.Is(err, ErrNoCurrentRelease), "%v", err)
+
+	_, _, err = NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{InvalidAction: RemoveInvalidBuilds})
+	s.True(errors.Is(err, ErrInvalidOptions), "%v", err)
+}
+
+func (s *CatalogSuite) TestNightlyBuildMetadata() {
+	s.makeBuild("mongodb-linux-x86_64-v4.4-latest", true)
+	md := BuildMetadata{
+		Version: "4.4.19-rc0-5-g8c7cd5b",
+		GitHash: "8c7cd5b",
+		Source:  MetadataSourceArchive,
+	}
+	s.require.NoError(WriteBuildMetadata(filepath.Join(s.dir, "mongodb-linux-x86_64-v4.4-latest"), md))
+
+	catalog, _, err := NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{SkipInvalid: true})
+	s.require.NoError(err)
+
+	reported, err := catalog.GetBuildMetadata("4.4-latest", string(Base), "linux", string(AMD64))
+	s.NoError(err)
+	s.Equal(md, reported)
+
+	info := BuildInfo{Version: "4.4-latest", Options: BuildOptions{Target: "linux", Arch: AMD64, Edition: Base}}
+	s.Equal(map[BuildInfo]BuildMetadata{info: md}, catalog.Metadata())
+
+	// abbreviated hashes match the full hash.
+	for _, hash := range []string{"8c7cd5b9ac1e6ea7dd3bcdc6ba6bfa31c4bfd1ad", "8C7CD5B", "8c7c"} {
+		path, err := catalog.GetByGitHash(hash, string(Base), "linux", string(AMD64), false)
+		s.NoError(err, hash)
+		s.Equal(filepath.Join(s.dir, "mongodb-linux-x86_64-v4.4-latest"), path)
+	}
+
+	_, err = catalog.GetByGitHash("8c7cd5c9ac1e6ea7dd3bcdc6ba6bfa31c4bfd1ad", string(Base), "linux", string(AMD64), false)
+	s.Error(err)
+}
+
+func (s *CatalogSuite) TestGetByGitHash() {
+	s.require.NoError(ioutil.WriteFile(filepath.Join(s.dir, "full.json"), []byte(testFeedData), 0644))
+	s.makeBuild("mongodb-linux-x86_64-4.4.0", true)
+	s.makeBuild("mongodb-linux-x86_64-v4.4-latest", true)
+	s.require.NoError(WriteBuildMetadata(filepath.Join(s.dir, "mongodb-linux-x86_64-v4.4-latest"), BuildMetadata{
+		Version: "4.4.2-rc0-12-gabc1234",
+		GitHash: "abc1234def5678abc1234def5678abc1234def56",
+		Source:  MetadataSourceBinary,
+	}))
+
+	catalog, _, err := NewCatalogWithOptions(context.Background(), s.dir, CatalogOptions{SkipInvalid: true})
+	s.require.NoError(err)
+
+	// git hashes from the feed
+	path, err := catalog.GetByGitHash("563487e1", string(Base), "linux", string(AMD64), false)
+	s.NoError(err)
+	s.Equal(filepath.Join(s.dir, "mongodb-linux-x86_64-4.4.0"), path)
+
+	md, err := catalog.GetBuildMetadata("4.4.0", string(Base), "linux", string(AMD64))
+	s.NoError(err)
+	s.Equal(MetadataSourceFeed, md.Source)
+
+	// git hashes recorded at extraction time
+	path, err = catalog.GetByGitHash("ABC1234", string(Base), "linux", string(AMD64), false)
+	s.NoError(err)
+	s.Equal(filepath.Join(s.dir, "mongodb-linux-x86_64-v4.4-latest"), path)
+
+	_, err = catalog.GetByGitHash("563487e1", string(Enterprise), "linux", string(AMD64), false)
+	s.Error(err)
+	_, err = catalog.GetByGitHash("ffffffff", string(Base), "linux", string(AMD64), false)
+	s.Error(err)
+
+	// 3.2.11 is not in the feed.
+	_, err = catalog.GetBuildMetadata("3.2.11", string(Base), "linux", string(AMD64))
+	s.True(errors.Is(err, ErrBuildNotFound), "%v", err)
+}
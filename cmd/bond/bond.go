@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evergreen-ci/bond"
+	"github.com/evergreen-ci/bond/recall"
+	"github.com/pkg/errors"
+)
+
+const usage = `usage: bond <command> [options]
+
+commands:
+  matrix    write the availability of builds in the feed by version and platform
+  reconcile fetch the builds in a config file and prune builds that it does not list
+`
+
+// bond is a command line interface for inspecting the MongoDB build
+// feed and managing local builds.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+
+	var err error
+	switch os.Args[1] {
+	case "matrix":
+		err = matrix(ctx, os.Args[2:])
+	case "reconcile":
+		err = reconcile(ctx, os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func matrix(ctx context.Context, args []string) error {
+	var (
+		feedPath string
+		format   string
+		versions string
+		targets  string
+		arches   string
+		editions string
+		releases bool
+		dropped  bool
+		offline  bool
+	)
+
+	flags := flag.NewFlagSet("matrix", flag.ExitOnError)
+	flags.StringVar(&feedPath, "feed", filepath.Join(os.TempDir(), "bond"), "directory or JSON file to cache the feed in")
+	flags.StringVar(&format, "format", bond.MatrixFormatMarkdown, "output format (json, csv or markdown)")
+	flags.StringVar(&versions, "versions", "", "comma-separated versions or release series to include")
+	flags.StringVar(&targets, "targets", "", "comma-separated targets to include")
+	flags.StringVar(&arches, "arches", "", "comma-separated architectures to include")
+	flags.StringVar(&editions, "editions", "", "comma-separated editions to include")
+	flags.BoolVar(&releases, "releases", false, "only include production releases")
+	flags.BoolVar(&dropped, "dropped", false, "only report platforms without builds for the newest version")
+	flags.BoolVar(&offline, "offline", false, "only use the cached feed, regardless of its age")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if offline {
+		ctx = bond.WithOffline(ctx)
+	}
+
+	feed, err := bond.GetArtifactsFeed(ctx, feedPath)
+	if err != nil {
+		return errors.Wrap(err, "getting feed")
+	}
+
+	opts := bond.MatrixOptions{
+		Versions:     splitList(versions),
+		Targets:      splitList(targets),
+		ReleasesOnly: releases,
+	}
+	for _, arch := range splitList(arches) {
+		opts.Arches = append(opts.Arches, bond.MongoDBArch(arch))
+	}
+	for _, edition := range splitList(editions) {
+		opts.Editions = append(opts.Editions, bond.MongoDBEdition(edition))
+	}
+
+	m := feed.GetCompatibilityMatrix(opts)
+	if !dropped {
+		return m.Write(os.Stdout, format)
+	}
+
+	for _, d := range m.Dropped() {
+		fmt.Fprintf(os.Stdout, "%s: last built for %s, missing from %s\n", d.Platform, d.LastVersion, d.FirstMissing)
+	}
+
+	return nil
+}
+
+func reconcile(ctx context.Context, args []string) error {
+	var (
+		configPath string
+		noPrune    bool
+		offline    bool
+	)
+
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	flags.StringVar(&configPath, "config", "bond.yaml", "YAML or JSON file that describes the builds to keep")
+	flags.BoolVar(&noPrune, "no-prune", false, "fetch missing builds without pruning unlisted builds")
+	flags.BoolVar(&offline, "offline", false, "only use the cached feed and archives")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	conf, err := recall.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if noPrune {
+		conf.Retention.Prune = false
+	}
+	if offline {
+		ctx = bond.WithOffline(ctx)
+	}
+
+	report, err := recall.Reconcile(ctx, conf)
+	if report != nil {
+		for _, res := range report.Builds {
+			status := "cached"
+			if res.Downloaded {
+				status = "downloaded"
+			}
+			fmt.Fprintf(os.Stdout, "%s %s: %s\n", status, res.Release, res.Archive)
+		}
+		for _, fn := range report.Pruned {
+			fmt.Fprintf(os.Stdout, "pruned %s\n", fn)
+		}
+	}
+
+	return errors.Wrap(err, "reconciling builds")
+}
+
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -21,4 +21,8 @@ const (
 	POWER               = "ppc64le"
 	AMD64               = "x86_64"
 	X86                 = "i686"
+	ARM64               = "aarch64"
+	// MacOSARM64 is the spelling of ARM64 in the feed and in the
+	// archive names of macOS builds.
+	MacOSARM64 = "arm64"
 )
@@ -2,15 +2,19 @@ package bond
 
 import (
 	"bufio"
+	"bytes"
 	"context"
-	"io/ioutil"
+	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/mongodb/grip"
 	"github.com/mongodb/grip/message"
@@ -21,10 +25,10 @@ import (
 // on the current environment and falls back to the generic builds for
 // a platform.
 //
-// In situations builds target a specific minor release of a platform
-// (and specify that version in their name) (e.g. debian and rhel),
-// the current platform must match the build
-// platform exactly.
+// The target is the canonical feed target for the distro's release
+// (e.g. "rhel80" for RHEL 8.x, or "amazon2" for Amazon Linux 2), which
+// may not be available for every version. Use
+// ArtifactsFeed.ResolveTarget to find a target that exists in the feed.
 func GetTargetDistro() string {
 	t, err := getDistro()
 	if err != nil {
@@ -41,194 +45,698 @@ func GetTargetDistro() string {
 }
 
 func getDistro() (string, error) {
-	info, err := collectReleaseInfo()
+	info, err := DetectDistro()
 	if err != nil {
 		return "", err
 	}
 
-	var out string
-	if fileExists("/etc/os-release") {
-		out = info.getKey("id") + cleanVersion(info.getKey("version_id"))
-	} else if fileExists("/etc/lsb-release") {
-		out = info.getKey("distro_id") + cleanVersion(info.getKey("distrib_release"))
-	} else {
-		out = info.getKey("distro_by_induction") + cleanVersion(info.getKey("version_by_induction"))
+	return info.Target()
+}
+
+// Distro families, which group distros that can run the same builds.
+const (
+	DistroFamilyRHEL   = "rhel"
+	DistroFamilyAmazon = "amazon"
+	DistroFamilyUbuntu = "ubuntu"
+	DistroFamilyDebian = "debian"
+	DistroFamilySUSE   = "suse"
+)
+
+// distroFamilies maps os-release IDs to distro families. Distros not
+// in this map are matched by their ID_LIKE values.
+var distroFamilies = map[string]string{
+	"rhel":          DistroFamilyRHEL,
+	"centos":        DistroFamilyRHEL,
+	"rocky":         DistroFamilyRHEL,
+	"almalinux":     DistroFamilyRHEL,
+	"ol":            DistroFamilyRHEL,
+	"fedora":        DistroFamilyRHEL,
+	"amzn":          DistroFamilyAmazon,
+	"ubuntu":        DistroFamilyUbuntu,
+	"debian":        DistroFamilyDebian,
+	"sles":          DistroFamilySUSE,
+	"sled":          DistroFamilySUSE,
+	"suse":          DistroFamilySUSE,
+	"opensuse":      DistroFamilySUSE,
+	"opensuse-leap": DistroFamilySUSE,
+}
+
+// ubuntuCodenames maps the codenames of Ubuntu releases to their
+// versions, for derivatives that only report the codename.
+var ubuntuCodenames = map[string]string{
+	"trusty": "14.04",
+	"xenial": "16.04",
+	"bionic": "18.04",
+	"focal":  "20.04",
+	"jammy":  "22.04",
+	"noble":  "24.04",
+}
+
+// DistroInfo describes a Linux distribution, using the fields of the
+// os-release file.
+type DistroInfo struct {
+	ID        string   `bson:"id" json:"id" yaml:"id"`
+	IDLike    []string `bson:"id_like,omitempty" json:"id_like,omitempty" yaml:"id_like,omitempty"`
+	VersionID string   `bson:"version_id" json:"version_id" yaml:"version_id"`
+	Codename  string   `bson:"codename,omitempty" json:"codename,omitempty" yaml:"codename,omitempty"`
+	Name      string   `bson:"name,omitempty" json:"name,omitempty" yaml:"name,omitempty"`
+}
+
+// ParseOSRelease parses the contents of an os-release file, as
+// described in os-release(5).
+func ParseOSRelease(data []byte) DistroInfo {
+	fields := parseReleaseFields(data)
+
+	info := DistroInfo{
+		ID:        strings.ToLower(fields["ID"]),
+		IDLike:    strings.Fields(strings.ToLower(fields["ID_LIKE"])),
+		VersionID: fields["VERSION_ID"],
+		Codename:  fields["UBUNTU_CODENAME"],
+		Name:      fields["PRETTY_NAME"],
+	}
+	if info.Codename == "" {
+		info.Codename = fields["VERSION_CODENAME"]
+	}
+	if info.Name == "" {
+		info.Name = fields["NAME"]
 	}
 
-	if out == "" {
-		return "", errors.New("this operating system is not supported, use a generic build")
+	return info
+}
+
+func parseReleaseFields(data []byte) map[string]string {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(parts[1])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		fields[strings.TrimSpace(parts[0])] = value
 	}
 
-	return out, nil
+	return fields
 }
 
-////////////////////////////////////////////////////////////////////////
-//
-// utility for collection system information.
+func (d DistroInfo) String() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return strings.TrimSpace(d.ID + " " + d.VersionID)
+}
+
+// Family returns the family of distros that this distro belongs to,
+// based on its ID and falling back to its ID_LIKE values, or an
+// empty string if the distro is not supported.
+func (d DistroInfo) Family() string {
+	if family, ok := distroFamilies[d.ID]; ok {
+		return family
+	}
 
-// if there was justice in the world, we could just use this, but
-// legacy systems are different enough that we can't really trust
-// them, and have to do a lot of fiddling.
+	for _, like := range d.IDLike {
+		if family, ok := distroFamilies[like]; ok {
+			return family
+		}
+	}
 
-type releaseInfo struct {
-	mu   sync.RWMutex
-	data map[string]string
+	return ""
 }
 
-func collectReleaseInfo() (*releaseInfo, error) {
-	files, err := filepath.Glob("/etc/*release")
-	if err != nil {
-		return nil, errors.Wrap(err, "finding release file")
+// release returns the family and the version of the family's release
+// that is equivalent to this distro. The version differs from the
+// distro's own version for derivatives that don't share the version
+// numbers of their family (e.g. Fedora or Linux Mint).
+func (d DistroInfo) release() (distroRelease, error) {
+	family := d.Family()
+	if family == "" {
+		return distroRelease{}, errors.Errorf("distro '%s' is not supported", d)
 	}
 
-	if len(files) == 0 {
-		return nil, errors.New("found no matching release file")
+	version := d.VersionID
+	if family == DistroFamilyUbuntu && d.ID != "ubuntu" {
+		version = ubuntuCodenames[d.Codename]
 	}
 
-	info := &releaseInfo{data: map[string]string{}}
-	catcher := grip.NewBasicCatcher()
-	for _, fn := range files {
-		func() {
-			file, err := os.Open(fn)
-			if err != nil {
-				catcher.Add(err)
-				return
-			}
-			defer file.Close()
-
-			scanner := bufio.NewScanner(file)
-			contents := []string{}
-			for scanner.Scan() {
-				line := scanner.Text()
-				contents = append(contents, line)
-				parts := strings.SplitN(line, "=", 2)
-				if len(parts) != 2 {
-					continue
-				}
-				info.data[strings.ToLower(parts[0])] = strings.Trim(parts[1], "\"'")
-			}
+	major, minor, err := parseDistroVersion(version)
+	if err != nil {
+		return distroRelease{}, errors.Wrapf(err, "parsing version of distro '%s'", d)
+	}
 
-			catcher.Add(scanner.Err())
-		}()
+	rel := distroRelease{family: family, major: major, minor: minor}
+	switch {
+	case d.ID == "fedora":
+		// fedora isn't a build target, so use the RHEL release
+		// that was branched from the closest fedora release.
+		switch {
+		case major >= 34:
+			rel.major = 9
+		case major >= 28:
+			rel.major = 8
+		default:
+			rel.major = 7
+		}
+		rel.minor = 0
+	case family == DistroFamilyAmazon && major > 2 && major < 2023:
+		// the original Amazon Linux is versioned by date.
+		rel.major = 1
+		rel.minor = 0
 	}
 
-	catcher.Add(info.addExtraInfo())
+	return rel, nil
+}
 
-	if catcher.HasErrors() {
-		return nil, catcher.Resolve()
+// Target returns the canonical feed target for the distro's release,
+// e.g. "rhel80", "ubuntu2204", "amazon2" or "debian10".
+func (d DistroInfo) Target() (string, error) {
+	rel, err := d.release()
+	if err != nil {
+		return "", err
 	}
 
-	return info, nil
+	return rel.target(), nil
 }
 
-func (i *releaseInfo) addExtraInfo() error {
-	const (
-		versionKey = "version_by_induction"
-		distroKey  = "distro_by_induction"
-	)
+func parseDistroVersion(version string) (int, int, error) {
+	if version == "" {
+		return 0, 0, errors.New("version is not specified")
+	}
 
-	i.mu.Lock()
-	defer i.mu.Unlock()
+	parts := strings.SplitN(version, ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "parsing major version '%s'", version)
+	}
 
-	if fileExists("/etc/redhat-release") {
-		file, err := fileContents("/etc/redhat-release")
+	var minor int
+	if len(parts) > 1 {
+		minor, err = strconv.Atoi(parts[1])
 		if err != nil {
-			return err
+			return 0, 0, errors.Wrapf(err, "parsing minor version '%s'", version)
 		}
+	}
+
+	return major, minor, nil
+}
+
+type distroRelease struct {
+	family string
+	major  int
+	minor  int
+}
 
-		i.data[versionKey] = extractVersion(file)
+func (r distroRelease) String() string {
+	if r.family == DistroFamilyAmazon || r.family == DistroFamilySUSE {
+		return fmt.Sprintf("%s %d", r.family, r.major)
+	}
+	return fmt.Sprintf("%s %d.%d", r.family, r.major, r.minor)
+}
 
-		if strings.Contains(file, "Red Hat") {
-			i.data[distroKey] = "rhel"
-		} else if strings.Contains(file, "Fedora") {
-			i.data[distroKey] = "fedora"
+func (r distroRelease) target() string {
+	switch r.family {
+	case DistroFamilyRHEL:
+		// before RHEL 7, builds targeted a specific minor release.
+		switch r.major {
+		case 5:
+			return "rhel57"
+		case 6:
+			return "rhel62"
 		}
-	} else if fileExists("/etc/system-release") {
-		file, err := fileContents("/etc/system-release")
-		if err != nil {
-			return err
+		return fmt.Sprintf("rhel%d0", r.major)
+	case DistroFamilyAmazon:
+		if r.major == 1 {
+			return "amazon"
+		}
+		return fmt.Sprintf("amazon%d", r.major)
+	case DistroFamilyUbuntu:
+		return fmt.Sprintf("ubuntu%02d%02d", r.major, r.minor)
+	case DistroFamilyDebian:
+		// before Debian 10, builds targeted a specific minor release.
+		switch r.major {
+		case 7:
+			return "debian71"
+		case 8:
+			return "debian81"
+		case 9:
+			return "debian92"
 		}
+		return fmt.Sprintf("debian%d", r.major)
+	case DistroFamilySUSE:
+		return fmt.Sprintf("suse%d", r.major)
+	}
+
+	return ""
+}
 
-		if strings.Contains(file, "Amazon Linux") {
-			i.data[distroKey] = "amzn64"
+var targetPattern = regexp.MustCompile(`^(rhel|amazon|amzn64|ubuntu|debian|suse)(\d*)$`)
+
+// parseTarget returns the distro release that a feed target was built
+// for. Targets for other platforms, and generic targets, are not
+// parsed.
+func parseTarget(target string) (distroRelease, bool) {
+	match := targetPattern.FindStringSubmatch(target)
+	if match == nil {
+		return distroRelease{}, false
+	}
+
+	family, digits := match[1], match[2]
+	number, _ := strconv.Atoi(digits)
+	rel := distroRelease{family: family}
+
+	switch family {
+	case "amzn64":
+		rel.family = DistroFamilyAmazon
+		rel.major = 1
+	case DistroFamilyAmazon:
+		rel.major = 1
+		if digits != "" {
+			rel.major = number
 		}
-	} else if commandExists("lsb_release") {
-		name, err := commandOutput("lsb_release", "-s", "-i")
-		if err != nil {
-			return err
+	case DistroFamilyUbuntu:
+		if len(digits) != 4 {
+			return distroRelease{}, false
+		}
+		rel.major, rel.minor = number/100, number%100
+	case DistroFamilyRHEL:
+		if len(digits) < 2 {
+			return distroRelease{}, false
+		}
+		rel.major, rel.minor = number/10, number%10
+	case DistroFamilyDebian:
+		// debian10 and later name the major release, earlier
+		// targets name the major and minor release (debian92).
+		if number >= 10 && number < 20 {
+			rel.major = number
+		} else {
+			rel.major, rel.minor = number/10, number%10
 		}
+	case DistroFamilySUSE:
+		rel.major = number
+	}
+
+	if digits == "" && rel.family != DistroFamilyAmazon {
+		return distroRelease{}, false
+	}
+
+	return rel, true
+}
+
+// TargetMatch describes how closely a target matches a distro, from
+// the best to the worst match.
+type TargetMatch int
+
+// Specific values for TargetMatch.
+const (
+	TargetMatchExact TargetMatch = iota
+	TargetMatchMinorRelease
+	TargetMatchOlderRelease
+	TargetMatchGeneric
+)
+
+func (m TargetMatch) String() string {
+	switch m {
+	case TargetMatchExact:
+		return "exact"
+	case TargetMatchMinorRelease:
+		return "minor-release"
+	case TargetMatchOlderRelease:
+		return "older-release"
+	case TargetMatchGeneric:
+		return "generic"
+	default:
+		return "unknown"
+	}
+}
 
-		if name != "RedHatEnterpriseServer" || !strings.Contains(name, "CentOS") {
-			return errors.Errorf("release named '%s' is not supported", name)
+// TargetResolution describes the feed target chosen for a distro, and
+// why it was chosen.
+type TargetResolution struct {
+	Target string      `bson:"target" json:"target" yaml:"target"`
+	Exact  bool        `bson:"exact" json:"exact" yaml:"exact"`
+	Match  TargetMatch `bson:"match" json:"match" yaml:"match"`
+	Reason string      `bson:"reason" json:"reason" yaml:"reason"`
+}
+
+// ResolveTarget chooses the target from the given list of available
+// targets that is the closest match for the distro. The preference
+// is:
+//
+//   - the distro's canonical target.
+//   - a target for another minor release of the same major release.
+//   - a target for an older release of the same family, newest first.
+//   - a generic linux target.
+//
+// Builds for older releases often depend on libraries that are no
+// longer installed by default, which the reason notes.
+func (d DistroInfo) ResolveTarget(targets []string) (TargetResolution, error) {
+	ranked, relErr := d.rankTargets(targets)
+	if len(ranked) > 0 {
+		return ranked[0], nil
+	}
+
+	if relErr != nil {
+		return TargetResolution{}, errors.Wrap(relErr, "no generic build available")
+	}
+
+	return TargetResolution{}, errors.Wrapf(ErrBuildNotFound, "no build compatible with %s in targets [%s]", d, strings.Join(targets, ", "))
+}
+
+// RankTargets returns every target from the given list of available
+// targets that is compatible with the distro, from the best to the
+// worst match, in the order of preference of ResolveTarget.
+func (d DistroInfo) RankTargets(targets []string) []TargetResolution {
+	ranked, _ := d.rankTargets(targets)
+	return ranked
+}
+
+func (d DistroInfo) rankTargets(targets []string) ([]TargetResolution, error) {
+	var ranked []TargetResolution
+	rel, relErr := d.release()
+	if relErr == nil {
+		canonical := rel.target()
+
+		var sameMajor, older []distroRelease
+		byRelease := map[distroRelease]string{}
+		for _, t := range targets {
+			if t == canonical {
+				ranked = append(ranked, TargetResolution{
+					Target: t,
+					Exact:  true,
+					Match:  TargetMatchExact,
+					Reason: fmt.Sprintf("'%s' is the target for %s", t, d),
+				})
+				continue
+			}
+
+			candidate, ok := parseTarget(t)
+			if !ok || candidate.family != rel.family {
+				continue
+			}
+			if _, ok = byRelease[candidate]; ok {
+				continue
+			}
+			byRelease[candidate] = t
+
+			switch {
+			case candidate.major == rel.major:
+				sameMajor = append(sameMajor, candidate)
+			case candidate.major < rel.major:
+				older = append(older, candidate)
+			}
 		}
 
-		ver, err := commandOutput("lsb_release", "-s", "-r")
-		if err != nil {
-			return err
+		// prefer the newest minor release that isn't newer than the
+		// distro, then the oldest newer one.
+		sort.Slice(sameMajor, func(i, j int) bool {
+			a, b := sameMajor[i], sameMajor[j]
+			if (a.minor <= rel.minor) != (b.minor <= rel.minor) {
+				return a.minor <= rel.minor
+			}
+			if a.minor <= rel.minor {
+				return a.minor > b.minor
+			}
+			return a.minor < b.minor
+		})
+		for _, candidate := range sameMajor {
+			t := byRelease[candidate]
+			ranked = append(ranked, TargetResolution{
+				Target: t,
+				Match:  TargetMatchMinorRelease,
+				Reason: fmt.Sprintf("no '%s' build, using '%s' built for %s on %s", canonical, t, candidate, d),
+			})
 		}
 
-		i.data[versionKey] = ver
-		i.data[distroKey] = "rhel"
+		sort.Slice(older, func(i, j int) bool {
+			if older[i].major != older[j].major {
+				return older[i].major > older[j].major
+			}
+			return older[i].minor > older[j].minor
+		})
+		for _, candidate := range older {
+			t := byRelease[candidate]
+			ranked = append(ranked, TargetResolution{
+				Target: t,
+				Match:  TargetMatchOlderRelease,
+				Reason: fmt.Sprintf("no '%s' build, using '%s' built for the older release %s on %s, which may require compatibility libraries",
+					canonical, t, candidate, d),
+			})
+		}
 	}
 
-	return nil
+	for _, t := range targets {
+		if t == "linux" || strings.HasPrefix(t, "linux_") {
+			reason := fmt.Sprintf("no build for %s, using the generic linux build '%s'", d, t)
+			if relErr != nil {
+				reason = fmt.Sprintf("%s (%s)", reason, relErr.Error())
+			}
+			ranked = append(ranked, TargetResolution{Target: t, Match: TargetMatchGeneric, Reason: reason})
+		}
+	}
+
+	return ranked, relErr
 }
 
-func (i *releaseInfo) getKey(key string) string {
-	i.mu.RLock()
-	defer i.mu.RUnlock()
+// ResolveTarget chooses a target for the distro from the targets in
+// the feed for a release, edition and architecture. The release may
+// be a specific version or a series with a "-current" or "-stable"
+// suffix.
+func (feed *ArtifactsFeed) ResolveTarget(release string, distro DistroInfo, arch MongoDBArch, edition MongoDBEdition) (TargetResolution, error) {
+	version, err := feed.resolveRelease(release)
+	if err != nil {
+		return TargetResolution{}, err
+	}
+
+	var targets []string
+	for _, dl := range version.Downloads {
+		if dl.Arch == arch && dl.Edition == edition {
+			targets = append(targets, dl.Target)
+		}
+	}
+
+	res, err := distro.ResolveTarget(targets)
+	if err != nil {
+		return TargetResolution{}, errors.Wrapf(err, "resolving target for version '%s', edition '%s', arch '%s'",
+			version.Version, edition, arch)
+	}
 
-	return i.data[key]
+	return res, nil
 }
 
 ////////////////////////////////////////////////////////////////////////
 //
-// util functions
+// detecting the distro of a system
+
+// CommandRunner runs a command on the system being inspected and
+// returns its output.
+type CommandRunner func(ctx context.Context, name string, args ...string) (string, error)
+
+// DistroDetector determines the distro of a system from the release
+// files in its root filesystem. The filesystem can be the current
+// host's, a container image's root filesystem, or a copy of a remote
+// host's "/etc" directory.
+type DistroDetector struct {
+	// FS is the root filesystem of the system, with paths relative
+	// to "/" (e.g. "etc/os-release").
+	FS fs.FS
+	// Runner is used to run lsb_release on systems without release
+	// files. If it is nil, no commands are run.
+	Runner CommandRunner
+}
+
+// NewDistroDetector returns a detector for the system with its root
+// filesystem at root. Symbolic links in the root filesystem are
+// resolved relative to root rather than to the host's filesystem.
+// The detector does not run commands.
+func NewDistroDetector(root string) *DistroDetector {
+	return &DistroDetector{FS: rootFS(root)}
+}
+
+// DetectDistro determines the distro of the current system from its
+// os-release file, falling back to legacy release files and
+// lsb_release on systems without one.
+func DetectDistro() (DistroInfo, error) {
+	d := &DistroDetector{FS: rootFS("/"), Runner: runHostCommand}
+	return d.Detect(context.Background())
+}
 
-func fileExists(path string) bool {
-	if _, err := os.Stat(path); !os.IsNotExist(err) {
-		return true
+// Detect determines the distro of the system from its os-release
+// file, falling back to legacy release files and lsb_release on
+// systems without one.
+func (d *DistroDetector) Detect(ctx context.Context) (DistroInfo, error) {
+	if d.FS == nil {
+		return DistroInfo{}, errors.New("distro detector has no filesystem")
+	}
+
+	for _, fn := range []string{"etc/os-release", "usr/lib/os-release"} {
+		data, err := fs.ReadFile(d.FS, fn)
+		if err == nil {
+			return ParseOSRelease(data), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return DistroInfo{}, errors.Wrapf(err, "reading '/%s'", fn)
+		}
 	}
 
-	return false
+	return d.detectLegacy(ctx)
 }
 
-func fileContents(path string) (string, error) {
-	out, err := ioutil.ReadFile(path)
+// Target returns the canonical feed target for the system's distro.
+func (d *DistroDetector) Target(ctx context.Context) (string, error) {
+	info, err := d.Detect(ctx)
 	if err != nil {
 		return "", err
 	}
-	return string(out), nil
+
+	return info.Target()
 }
 
-func commandExists(cmd string) bool {
-	cmd, err := exec.LookPath(cmd)
-	if err != nil || cmd == "" {
-		return false
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)*`)
+
+func (d *DistroDetector) detectLegacy(ctx context.Context) (DistroInfo, error) {
+	if data, err := fs.ReadFile(d.FS, "etc/redhat-release"); err == nil {
+		file := string(data)
+		info := DistroInfo{Name: strings.TrimSpace(file), VersionID: versionPattern.FindString(file)}
+		switch {
+		case strings.Contains(file, "Red Hat"):
+			info.ID = "rhel"
+		case strings.Contains(file, "CentOS"):
+			info.ID = "centos"
+		case strings.Contains(file, "Fedora"):
+			info.ID = "fedora"
+		default:
+			return DistroInfo{}, errors.Errorf("release '%s' is not supported", info.Name)
+		}
+		return info, nil
+	}
+
+	if data, err := fs.ReadFile(d.FS, "etc/system-release"); err == nil {
+		file := string(data)
+		if !strings.Contains(file, "Amazon Linux") {
+			return DistroInfo{}, errors.Errorf("release '%s' is not supported", strings.TrimSpace(file))
+		}
+		return DistroInfo{ID: "amzn", Name: strings.TrimSpace(file), VersionID: versionPattern.FindString(file)}, nil
+	}
+
+	if data, err := fs.ReadFile(d.FS, "etc/lsb-release"); err == nil {
+		fields := parseReleaseFields(data)
+		return DistroInfo{
+			ID:        strings.ToLower(fields["DISTRIB_ID"]),
+			VersionID: fields["DISTRIB_RELEASE"],
+			Codename:  fields["DISTRIB_CODENAME"],
+			Name:      fields["DISTRIB_DESCRIPTION"],
+		}, nil
+	}
+
+	if d.Runner == nil {
+		return DistroInfo{}, errors.New("found no matching release file")
 	}
 
-	return true
+	name, err := d.Runner(ctx, "lsb_release", "-s", "-i")
+	if err != nil {
+		return DistroInfo{}, errors.Wrap(err, "found no matching release file, and could not run lsb_release")
+	}
+	name = strings.TrimSpace(name)
+
+	if name != "RedHatEnterpriseServer" && !strings.Contains(name, "CentOS") {
+		return DistroInfo{}, errors.Errorf("release named '%s' is not supported", name)
+	}
+
+	ver, err := d.Runner(ctx, "lsb_release", "-s", "-r")
+	if err != nil {
+		return DistroInfo{}, errors.Wrap(err, "running lsb_release")
+	}
+
+	return DistroInfo{ID: "rhel", VersionID: strings.TrimSpace(ver), Name: name}, nil
 }
 
-func commandOutput(cmd string, args ...string) (string, error) {
-	out, err := exec.Command(cmd, args...).CombinedOutput()
+func runHostCommand(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
 	if err != nil {
-		return "", err
+		return "", errors.Wrapf(err, "running '%s'", name)
 	}
 	return string(out), nil
 }
 
-func cleanVersion(s string) string { return strings.Replace(s, ".", "", -1) }
+// rootFS is a filesystem rooted at a directory, which resolves
+// symbolic links (such as the common "/etc/os-release" to
+// "/usr/lib/os-release" link) within the directory. Links in every
+// component of a path are resolved, so no path refers to anything
+// outside of the directory.
+type rootFS string
 
-func extractVersion(s string) string {
-	re, err := regexp.Compile(`.*(\d*\.*\d*).*`)
+const maxSymlinks = 16
+
+func (root rootFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	rel, err := root.resolve(name)
 	if err != nil {
-		return ""
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
 	}
-	parts := re.FindAllString(s, 1)
-	if len(parts) != 1 {
-		return ""
+
+	return os.Open(filepath.Join(string(root), filepath.FromSlash(rel)))
+}
+
+// resolve returns the path of name relative to the root, following
+// the symbolic links of each component of the path. Absolute links
+// refer to the root, and relative links can't refer to anything above
+// it.
+func (root rootFS) resolve(name string) (string, error) {
+	var resolved []string
+	parts := strings.Split(name, "/")
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+
+		fn := filepath.Join(string(root), filepath.Join(resolved...), part)
+		info, err := os.Lstat(fn)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = append(resolved, part)
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", errors.New("too many levels of symbolic links")
+		}
+
+		link, err := os.Readlink(fn)
+		if err != nil {
+			return "", err
+		}
+
+		link = filepath.ToSlash(link)
+		if path.IsAbs(link) {
+			resolved = nil
+		}
+		parts = append(strings.Split(link, "/"), parts...)
 	}
 
-	return parts[0]
+	if len(resolved) == 0 {
+		return ".", nil
+	}
+	return path.Join(resolved...), nil
 }
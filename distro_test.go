@@ -0,0 +1,275 @@
+package bond
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	assert := assert.New(t)
+
+	info := ParseOSRelease([]byte(`# comment
+NAME="Linux Mint"
+VERSION="21.2 (Victoria)"
+ID=linuxmint
+ID_LIKE="ubuntu debian"
+PRETTY_NAME="Linux Mint 21.2"
+VERSION_ID="21.2"
+VERSION_CODENAME=victoria
+UBUNTU_CODENAME=jammy
+`))
+
+	assert.Equal("linuxmint", info.ID)
+	assert.Equal([]string{"ubuntu", "debian"}, info.IDLike)
+	assert.Equal("21.2", info.VersionID)
+	assert.Equal("jammy", info.Codename)
+	assert.Equal("Linux Mint 21.2", info.Name)
+	assert.Equal(DistroFamilyUbuntu, info.Family())
+
+	target, err := info.Target()
+	assert.NoError(err)
+	assert.Equal("ubuntu2204", target)
+}
+
+func TestDistroTarget(t *testing.T) {
+	for target, info := range map[string]DistroInfo{
+		"rhel80":     {ID: "rhel", VersionID: "8.10"},
+		"rhel90":     {ID: "rocky", IDLike: []string{"rhel", "centos", "fedora"}, VersionID: "9.3"},
+		"rhel70":     {ID: "centos", VersionID: "7"},
+		"rhel62":     {ID: "centos", VersionID: "6.10"},
+		"amazon2":    {ID: "amzn", VersionID: "2"},
+		"amazon2023": {ID: "amzn", VersionID: "2023"},
+		"amazon":     {ID: "amzn", VersionID: "2018.03"},
+		"ubuntu2204": {ID: "ubuntu", VersionID: "22.04"},
+		"ubuntu1804": {ID: "ubuntu", VersionID: "18.04"},
+		"debian10":   {ID: "debian", VersionID: "10"},
+		"debian92":   {ID: "debian", VersionID: "9"},
+		"suse15":     {ID: "sles", VersionID: "15.4"},
+		"suse12":     {ID: "opensuse-leap", VersionID: "12.5"},
+	} {
+		actual, err := info.Target()
+		assert.NoError(t, err, target)
+		assert.Equal(t, target, actual)
+	}
+
+	for _, info := range []DistroInfo{
+		{ID: "arch"},
+		{ID: "ubuntu"},
+		{ID: "rhel", VersionID: "eight"},
+		{ID: "linuxmint", IDLike: []string{"ubuntu"}, VersionID: "21.2"},
+	} {
+		_, err := info.Target()
+		assert.Error(t, err, info.ID)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	for target, expected := range map[string]distroRelease{
+		"rhel62":     {family: DistroFamilyRHEL, major: 6, minor: 2},
+		"rhel82":     {family: DistroFamilyRHEL, major: 8, minor: 2},
+		"amazon":     {family: DistroFamilyAmazon, major: 1},
+		"amzn64":     {family: DistroFamilyAmazon, major: 1},
+		"amazon2023": {family: DistroFamilyAmazon, major: 2023},
+		"ubuntu2004": {family: DistroFamilyUbuntu, major: 20, minor: 4},
+		"debian92":   {family: DistroFamilyDebian, major: 9, minor: 2},
+		"debian11":   {family: DistroFamilyDebian, major: 11},
+		"suse15":     {family: DistroFamilySUSE, major: 15},
+	} {
+		actual, ok := parseTarget(target)
+		assert.True(t, ok, target)
+		assert.Equal(t, expected, actual, target)
+	}
+
+	for _, target := range []string{"linux_x86_64", "macos", "windows", "rhel", "ubuntu04", "osx-ssl"} {
+		_, ok := parseTarget(target)
+		assert.False(t, ok, target)
+	}
+}
+
+func TestDistroResolveTarget(t *testing.T) {
+	targets := []string{"rhel70", "rhel80", "rhel82", "amazon", "amazon2", "ubuntu1804", "ubuntu2004", "debian92", "debian10", "suse12", "linux_x86_64"}
+
+	for name, test := range map[string]struct {
+		info   DistroInfo
+		target string
+		exact  bool
+	}{
+		"Exact":              {info: DistroInfo{ID: "rhel", VersionID: "8.10"}, target: "rhel80", exact: true},
+		"OlderMajor":         {info: DistroInfo{ID: "rhel", VersionID: "9.2"}, target: "rhel82"},
+		"OlderUbuntu":        {info: DistroInfo{ID: "ubuntu", VersionID: "22.04"}, target: "ubuntu2004"},
+		"AmazonDateVersion":  {info: DistroInfo{ID: "amzn", VersionID: "2018.03"}, target: "amazon", exact: true},
+		"AmazonLinux2023":    {info: DistroInfo{ID: "amzn", VersionID: "2023"}, target: "amazon2"},
+		"Debian":             {info: DistroInfo{ID: "debian", VersionID: "12"}, target: "debian10"},
+		"Fedora":             {info: DistroInfo{ID: "fedora", VersionID: "30"}, target: "rhel80", exact: true},
+		"NewerFamily":        {info: DistroInfo{ID: "suse", VersionID: "11.4"}, target: "linux_x86_64"},
+		"UnsupportedDistro":  {info: DistroInfo{ID: "arch"}, target: "linux_x86_64"},
+		"DerivativeByIDLike": {info: DistroInfo{ID: "pop", IDLike: []string{"ubuntu", "debian"}, Codename: "bionic"}, target: "ubuntu1804", exact: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res, err := test.info.ResolveTarget(targets)
+			require.NoError(t, err)
+			assert.Equal(t, test.target, res.Target)
+			assert.Equal(t, test.exact, res.Exact)
+			assert.NotEmpty(t, res.Reason)
+		})
+	}
+
+	t.Run("SameMajorPrefersOlderMinor", func(t *testing.T) {
+		res, err := DistroInfo{ID: "rhel", VersionID: "7.9"}.ResolveTarget([]string{"rhel72", "rhel71", "rhel80"})
+		require.NoError(t, err)
+		assert.Equal(t, "rhel72", res.Target)
+
+		res, err = DistroInfo{ID: "rhel", VersionID: "8.0"}.ResolveTarget([]string{"rhel82", "rhel83", "rhel70"})
+		require.NoError(t, err)
+		assert.Equal(t, "rhel82", res.Target)
+	})
+
+	t.Run("NoCompatibleTarget", func(t *testing.T) {
+		_, err := DistroInfo{ID: "ubuntu", VersionID: "16.04"}.ResolveTarget([]string{"ubuntu1804", "rhel80"})
+		assert.Error(t, err)
+
+		_, err = DistroInfo{ID: "arch"}.ResolveTarget([]string{"rhel80"})
+		assert.Error(t, err)
+	})
+}
+
+func TestFeedResolveTarget(t *testing.T) {
+	feed := newTestFeed(t)
+	ubuntu := DistroInfo{ID: "ubuntu", VersionID: "22.04"}
+
+	res, err := feed.ResolveTarget("4.4.1", ubuntu, AMD64, CommunityTargeted)
+	require.NoError(t, err)
+	assert.Equal(t, "ubuntu2004", res.Target)
+	assert.False(t, res.Exact)
+
+	res, err = feed.ResolveTarget("4.4-current", DistroInfo{ID: "rocky", VersionID: "8.8"}, AMD64, Enterprise)
+	require.NoError(t, err)
+	assert.Equal(t, "rhel80", res.Target)
+	assert.True(t, res.Exact)
+
+	res, err = feed.ResolveTarget("4.4.0", ubuntu, AMD64, Base)
+	require.NoError(t, err)
+	assert.Equal(t, "linux_x86_64", res.Target)
+
+	_, err = feed.ResolveTarget("4.4.0", ubuntu, AMD64, CommunityTargeted)
+	assert.Error(t, err)
+	_, err = feed.ResolveTarget("3.2.0", ubuntu, AMD64, CommunityTargeted)
+	assert.Error(t, err)
+}
+
+func TestDistroDetectorFixtures(t *testing.T) {
+	for name, target := range map[string]string{
+		"rhel8":      "rhel80",
+		"centos7":    "rhel70",
+		"rocky9":     "rhel90",
+		"amazon2":    "amazon2",
+		"amazon2023": "amazon2023",
+		"debian11":   "debian11",
+		"ubuntu2204": "ubuntu2204",
+		"sles15":     "suse15",
+		"fedora38":   "rhel90",
+		// systems without an os-release file.
+		"centos6":    "rhel62",
+		"amazon2018": "amazon",
+	} {
+		t.Run(name, func(t *testing.T) {
+			actual, err := NewDistroDetector(filepath.Join("testdata", "distro", name)).Target(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, target, actual)
+		})
+	}
+}
+
+func TestDistroDetectorResolvesLinksInRoot(t *testing.T) {
+	// the fixture's os-release is an absolute link, which must not
+	// resolve to the host's os-release.
+	info, err := NewDistroDetector(filepath.Join("testdata", "distro", "ubuntu2204")).Detect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ubuntu", info.ID)
+	assert.Equal(t, "22.04", info.VersionID)
+	assert.Equal(t, "Ubuntu 22.04.3 LTS", info.Name)
+}
+
+func TestDistroDetectorResolvesIntermediateLinksInRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside", "etc")
+	inside := filepath.Join(root, "host", "lib")
+	for _, d := range []string{outside, inside, filepath.Join(root, "usr")} {
+		require.NoError(t, os.MkdirAll(d, 0755))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(outside, "os-release"), []byte("ID=gentoo\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(inside, "os-release"), []byte("ID=rocky\nVERSION_ID=\"9.3\"\n"), 0644))
+
+	// the directories, rather than the files, are links.
+	require.NoError(t, os.Symlink("../outside/etc", filepath.Join(root, "etc")))
+	require.NoError(t, os.Symlink("/host/lib", filepath.Join(root, "usr", "lib")))
+
+	_, err := fs.ReadFile(rootFS(root), "etc/os-release")
+	assert.True(t, errors.Is(err, fs.ErrNotExist), "links can't refer to anything above the root")
+
+	data, err := fs.ReadFile(rootFS(root), "usr/lib/os-release")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "rocky")
+
+	info, err := NewDistroDetector(root).Detect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "rocky", info.ID)
+
+	require.NoError(t, os.Symlink("loop", filepath.Join(root, "loop")))
+	_, err = fs.ReadFile(rootFS(root), "loop/os-release")
+	assert.Error(t, err)
+}
+
+func TestDistroDetectorWithFS(t *testing.T) {
+	detector := &DistroDetector{FS: fstest.MapFS{
+		"usr/lib/os-release": &fstest.MapFile{Data: []byte("ID=opensuse-leap\nVERSION_ID=\"15.5\"\n")},
+	}}
+
+	target, err := detector.Target(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "suse15", target)
+
+	_, err = (&DistroDetector{}).Detect(context.Background())
+	assert.Error(t, err)
+}
+
+func TestDistroDetectorCommandRunner(t *testing.T) {
+	root := filepath.Join("testdata", "distro", "empty")
+
+	_, err := NewDistroDetector(root).Detect(context.Background())
+	assert.Error(t, err, "detectors without a runner should not run commands")
+
+	var calls [][]string
+	detector := NewDistroDetector(root)
+	detector.Runner = func(_ context.Context, name string, args ...string) (string, error) {
+		calls = append(calls, append([]string{name}, args...))
+		switch args[1] {
+		case "-i":
+			return "RedHatEnterpriseServer\n", nil
+		case "-r":
+			return "7.9\n", nil
+		}
+		return "", errors.New("unexpected arguments")
+	}
+
+	target, err := detector.Target(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "rhel70", target)
+	assert.Len(t, calls, 2)
+
+	detector.Runner = func(_ context.Context, name string, args ...string) (string, error) {
+		return "Gentoo\n", nil
+	}
+	_, err = detector.Detect(context.Background())
+	assert.Error(t, err)
+}
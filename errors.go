@@ -0,0 +1,103 @@
+package bond
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+)
+
+// Errors that bond and recall wrap with the details of the failure,
+// so that callers can distinguish failures with errors.Is.
+var (
+	// ErrVersionNotFound is returned when a version, release or
+	// git hash is not in the feed.
+	ErrVersionNotFound = errors.New("version not found")
+	// ErrBuildNotFound is returned when a version has no build
+	// matching the build options, or a catalog has no matching
+	// build.
+	ErrBuildNotFound = errors.New("build not found")
+	// ErrNoCurrentRelease is returned when a series has no current
+	// (stable) release in the feed.
+	ErrNoCurrentRelease = errors.New("no current release")
+	// ErrInvalidOptions is returned when build options, or the
+	// options of an operation, are invalid.
+	ErrInvalidOptions = errors.New("invalid options")
+	// ErrChecksumMismatch is returned when the checksum of an
+	// archive does not match the expected checksum.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrDuplicateBuild is returned when a build is added to a
+	// catalog that already has a build with the same version and
+	// options.
+	ErrDuplicateBuild = errors.New("duplicate build")
+)
+
+// DownloadError is returned when a download fails, either because
+// the request failed, or because the server responded with an error
+// status.
+type DownloadError struct {
+	URL string
+	// StatusCode is the status of the response, or zero if there was
+	// no response.
+	StatusCode int
+	Err        error
+}
+
+func (e *DownloadError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("downloading '%s': %s", e.URL, e.Err)
+	}
+	return fmt.Sprintf("received status code %d (%s) for request to URL '%s'", e.StatusCode, http.StatusText(e.StatusCode), e.URL)
+}
+
+func (e *DownloadError) Unwrap() error { return e.Err }
+
+// IsDownloadError returns true if the error, or any error that it
+// wraps, is a *DownloadError.
+func IsDownloadError(err error) bool {
+	var dl *DownloadError
+	return errors.As(err, &dl)
+}
+
+// multiError combines several errors, and unlike the errors that
+// grip.Catcher resolves, remains compatible with errors.Is and
+// errors.As.
+type multiError []error
+
+func (e multiError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e multiError) Unwrap() []error { return e }
+
+// ResolveErrors returns the errors in the catcher, or nil if it has
+// none. Unlike catcher.Resolve, the errors remain compatible with
+// errors.Is and errors.As.
+func ResolveErrors(catcher grip.Catcher) error {
+	errs := catcher.Errors()
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return multiError(errs)
+	}
+}
+
+// ResolveInvalidOptions returns the errors in the catcher combined
+// with ErrInvalidOptions, or nil if it has none. Like ResolveErrors,
+// the errors remain compatible with errors.Is and errors.As.
+func ResolveInvalidOptions(catcher grip.Catcher) error {
+	err := ResolveErrors(catcher)
+	if err == nil || errors.Is(err, ErrInvalidOptions) {
+		return err
+	}
+	return multiError{err, ErrInvalidOptions}
+}
@@ -0,0 +1,125 @@
+package bond
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedErrors(t *testing.T) {
+	feed := newTestFeed(t)
+	opts := BuildOptions{Target: "linux_x86_64", Arch: AMD64, Edition: Base}
+	missing := BuildOptions{Target: "ubuntu2204", Arch: AMD64, Edition: Enterprise}
+
+	for name, test := range map[string]struct {
+		op       func() error
+		expected error
+	}{
+		"UnknownVersion": {
+			op:       func() error { _, _, err := feed.GetReleaseDownload("9.9.9", opts); return err },
+			expected: ErrVersionNotFound,
+		},
+		"UnknownGitHash": {
+			op:       func() error { _, err := feed.GetVersionByGitHash("ffffffff"); return err },
+			expected: ErrVersionNotFound,
+		},
+		"UnknownSeries": {
+			op:       func() error { _, err := feed.GetLatestArchive("9.9", opts); return err },
+			expected: ErrVersionNotFound,
+		},
+		"NoCurrentRelease": {
+			op:       func() error { _, err := feed.GetCurrentArchive("3.2", opts); return err },
+			expected: ErrNoCurrentRelease,
+		},
+		"NoCurrentReleaseForRelease": {
+			op:       func() error { _, err := feed.GetReleaseArchives("3.2-current", opts); return err },
+			expected: ErrNoCurrentRelease,
+		},
+		"NoBuild": {
+			op:       func() error { _, _, err := feed.GetReleaseDownload("4.4.1", missing); return err },
+			expected: ErrBuildNotFound,
+		},
+		"NoNightlyBuild": {
+			op:       func() error { _, err := feed.GetLatestArchive("4.4", missing); return err },
+			expected: ErrBuildNotFound,
+		},
+		"NoDownload": {
+			op: func() error {
+				version, ok := feed.GetVersion("4.4.1")
+				require.True(t, ok)
+				_, err := version.GetDownload(missing)
+				return err
+			},
+			expected: ErrBuildNotFound,
+		},
+		"Archives": {
+			op: func() error {
+				urls, errs := feed.GetArchives([]string{"9.9.9", "4.4.1", "9.9.8"}, opts)
+				for range urls {
+				}
+				err := <-errs
+				_, ok := <-errs
+				assert.False(t, ok, "errors are combined")
+				return err
+			},
+			expected: ErrVersionNotFound,
+		},
+		"InvalidOptions": {
+			op:       func() error { return BuildOptions{Target: "linux"}.Validate() },
+			expected: ErrInvalidOptions,
+		},
+		"InvalidMatrix": {
+			op:       func() error { return BuildMatrix{}.Validate() },
+			expected: ErrInvalidOptions,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := test.op()
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, test.expected), err.Error())
+		})
+	}
+}
+
+func TestDownloadError(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.NotFoundHandler())
+
+	err := DownloadFile(ctx, srv.URL+"/missing.tgz", filepath.Join(t.TempDir(), "missing.tgz"))
+	require.Error(t, err)
+	var dl *DownloadError
+	require.True(t, errors.As(err, &dl))
+	assert.Equal(t, http.StatusNotFound, dl.StatusCode)
+	assert.Equal(t, srv.URL+"/missing.tgz", dl.URL)
+	assert.Contains(t, err.Error(), "404")
+
+	srv.Close()
+	_, err = RefreshDownload(ctx, srv.URL+"/closed.tgz", filepath.Join(t.TempDir(), "closed.tgz"), false)
+	require.Error(t, err)
+	assert.True(t, IsDownloadError(err))
+	require.True(t, errors.As(err, &dl))
+	assert.Zero(t, dl.StatusCode)
+	assert.Error(t, dl.Unwrap())
+
+	assert.False(t, IsDownloadError(errors.New("error")))
+}
+
+func TestResolveInvalidOptions(t *testing.T) {
+	catcher := grip.NewCatcher()
+	assert.NoError(t, ResolveInvalidOptions(catcher))
+
+	catcher.New("must specify a target")
+	catcher.Wrap(ErrBuildNotFound, "resolving build")
+	err := ResolveInvalidOptions(catcher)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrInvalidOptions))
+	assert.True(t, errors.Is(err, ErrBuildNotFound), "the errors are not flattened")
+	assert.Contains(t, err.Error(), "must specify a target")
+}
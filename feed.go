@@ -8,21 +8,36 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mongodb/grip"
 	"github.com/pkg/errors"
 )
 
+// feedURL is the location of the MongoDB build information feed.
+const feedURL = "http://downloads.mongodb.org/full.json"
+
 // ArtifactsFeed represents the entire structure of the MongoDB build information feed.
 // See http://downloads.mongodb.org/full.json for an example.
 type ArtifactsFeed struct {
+	// Versions holds the versions from the most recent call to
+	// Reload. Reading it directly is not safe while the feed is
+	// reloaded; use GetVersions instead.
 	Versions []*ArtifactVersion
 
-	mutex sync.RWMutex
-	table map[string]*ArtifactVersion
-	dir   string
-	path  string
+	// snapshot holds the feed's data, which Reload replaces rather
+	// than modifies, so that readers never block or observe a
+	// partially reloaded feed. mutex serializes writers.
+	snapshot atomic.Pointer[feedSnapshot]
+	mutex    sync.RWMutex
+	hooks    []FeedHook
+	dir      string
+	path     string
+	url      string
+
+	subscribers []*feedSubscriber
+	refreshing  bool
 }
 
 // GetArtifactsFeed parses a ArtifactsFeed object from a file on the file system.
@@ -47,8 +62,8 @@ func GetArtifactsFeed(ctx context.Context, path string) (*ArtifactsFeed, error)
 // to return a feed object homed on a temporary directory.
 func NewArtifactsFeed(path string) (*ArtifactsFeed, error) {
 	f := &ArtifactsFeed{
-		table: make(map[string]*ArtifactVersion),
-		path:  path,
+		path: path,
+		url:  feedURL,
 	}
 
 	if path == "" {
@@ -81,74 +96,141 @@ func NewArtifactsFeed(path string) (*ArtifactsFeed, error) {
 // Populate updates the local copy of the full feed in the the feed's
 // cache if the local file doesn't exist or is older than the
 // specified TTL. Additional Populate parses the data feed, using the
-// Reload method.
+// Reload method, and then calls the feed's hooks with the changes to
+// the feed.
 func (feed *ArtifactsFeed) Populate(ctx context.Context, ttl time.Duration) error {
-	data, err := CacheDownload(ctx, ttl, "http://downloads.mongodb.org/full.json", feed.path, false)
+	data, err := CacheDownload(ctx, ttl, feed.url, feed.path, false)
 
 	if err != nil {
 		return errors.Wrap(err, "getting feed data")
 	}
 
+	old := summarizeFeed(feed)
 	if err = feed.Reload(data); err != nil {
 		return errors.Wrap(err, "reloading feed")
 	}
 
+	feed.runHooks(ctx, old)
+
 	return nil
 }
 
 // Reload takes the content of the full.json file and loads this data
 // into the current ArtifactsFeed object, overwriting any existing data.
+// The new data replaces the old data atomically: versions returned
+// before the reload are not modified.
 func (feed *ArtifactsFeed) Reload(data []byte) error {
-	// file exists, remove it if it's more than 48 hours old.
-	feed.mutex.Lock()
-	defer feed.mutex.Unlock()
-
-	err := json.Unmarshal(data, feed)
-	if err != nil {
+	// decode into a new value, since decoding into the existing
+	// versions would modify them while they're being read, and keep
+	// the values of fields that the new data omits.
+	out := struct {
+		Versions []*ArtifactVersion
+	}{}
+	if err := json.Unmarshal(data, &out); err != nil {
 		return errors.Wrap(err, "converting data from JSON")
 	}
 
-	if len(feed.table) > 0 {
-		feed.table = make(map[string]*ArtifactVersion)
+	snapshot := &feedSnapshot{
+		versions: out.Versions,
+		table:    make(map[string]*ArtifactVersion, len(out.Versions)),
 	}
-
-	for _, version := range feed.Versions {
-		feed.table[version.Version] = version
+	for _, version := range out.Versions {
+		snapshot.table[version.Version] = version
 		version.refresh()
 	}
 
-	return err
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	feed.Versions = out.Versions
+	feed.snapshot.Store(snapshot)
+
+	return nil
+}
+
+// feedSnapshot is the data of a feed, which is never modified once it
+// has been loaded.
+type feedSnapshot struct {
+	versions []*ArtifactVersion
+	table    map[string]*ArtifactVersion
+}
+
+func (feed *ArtifactsFeed) data() *feedSnapshot {
+	if snapshot := feed.snapshot.Load(); snapshot != nil {
+		return snapshot
+	}
+	return &feedSnapshot{}
+}
+
+// GetVersions returns all of the versions in the feed, in the order
+// of the feed, which is from the newest to the oldest.
+func (feed *ArtifactsFeed) GetVersions() []*ArtifactVersion {
+	versions := feed.data().versions
+	out := make([]*ArtifactVersion, len(versions))
+	copy(out, versions)
+	return out
 }
 
 // GetVersion takes a version string and returns the entire Artifacts version.
 // The second value indicates if that release exists in the current feed.
 func (feed *ArtifactsFeed) GetVersion(release string) (*ArtifactVersion, bool) {
-	feed.mutex.RLock()
-	defer feed.mutex.RUnlock()
-
-	version, ok := feed.table[release]
+	version, ok := feed.data().table[release]
 	return version, ok
 }
 
+// GetVersionByGitHash returns the version in the feed built from the
+// specified commit. The hash may be a full git hash or a unique
+// prefix of one. When several versions (e.g. a release candidate and
+// the final release) are built from the same commit, the most recent
+// version in the feed is returned.
+func (feed *ArtifactsFeed) GetVersionByGitHash(hash string) (*ArtifactVersion, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if hash == "" {
+		return nil, errors.New("must specify a git hash")
+	}
+
+	var match *ArtifactVersion
+	for _, version := range feed.data().versions {
+		if version.GitHash == "" || !strings.HasPrefix(strings.ToLower(version.GitHash), hash) {
+			continue
+		}
+
+		if match == nil {
+			match = version
+			continue
+		}
+
+		if !strings.EqualFold(match.GitHash, version.GitHash) {
+			return nil, errors.Errorf("git hash '%s' is ambiguous, matching at least '%s' and '%s'",
+				hash, match.GitHash, version.GitHash)
+		}
+	}
+
+	if match == nil {
+		return nil, errors.Wrapf(ErrVersionNotFound, "no version defined for git hash '%s'", hash)
+	}
+
+	return match, nil
+}
+
 // GetLatestArchive given a release series (e.g. 3.2, 3.0, or 3.0),
 // return the URL of the "latest" (e.g. nightly) build archive. These
 // builds are atypical, and given how they're produced, may not
 // necessarily reflect the most recent released or unreleased changes on a branch.
+//
+// Nightly builds are not in the feed, so the URL is derived from the
+// archive of a release in the series, preferably the .0 release, by
+// replacing the version in the archive's file name.
 func (feed *ArtifactsFeed) GetLatestArchive(series string, options BuildOptions) (string, error) {
 	series = coerceSeries(series)
 
 	if options.Debug {
-		return "", errors.New("debug symbols are not valid for nightly releases")
-	}
-
-	version, ok := feed.GetVersion(series + ".0")
-	if !ok {
-		return "", errors.Errorf("there is no .0 release for series '%s' in the feed", series)
+		return "", errors.Wrap(ErrBuildNotFound, "debug symbols are not valid for nightly releases")
 	}
 
-	dl, err := version.GetDownload(options)
+	version, dl, err := feed.getSeriesDownload(series, options)
 	if err != nil {
-		return "", errors.Wrapf(err, "fetching download information for series '%s'", series)
+		return "", err
 	}
 
 	isDev, err := version.isDevelopmentSeries()
@@ -157,18 +239,61 @@ func (feed *ArtifactsFeed) GetLatestArchive(series string, options BuildOptions)
 	}
 
 	// If it's a development series, we just replace the version with the word latest.
-	// Otherwise the branch name is in the file name, and we take the latest from the series release.
+	// Otherwise the branch name is in the file name.
+	name := "v" + series + "-latest"
 	if isDev {
-		return strings.Replace(dl.Archive.URL, version.Version, "latest", -1), nil
+		name = "latest"
+	}
+
+	return replaceArchiveVersion(dl.Archive.URL, version.Version, name)
+}
+
+// getSeriesDownload returns the download for the options from the .0
+// release of the series, or if the feed doesn't have one, from the
+// most recent release in the series that does.
+func (feed *ArtifactsFeed) getSeriesDownload(series string, options BuildOptions) (*ArtifactVersion, ArtifactDownload, error) {
+	if version, ok := feed.GetVersion(series + ".0"); ok {
+		dl, err := version.GetDownload(options)
+		if err == nil {
+			return version, dl, nil
+		}
+	}
+
+	found := false
+	for _, version := range feed.data().versions {
+		if !strings.HasPrefix(version.Version, series+".") {
+			continue
+		}
+		found = true
+
+		if dl, err := version.GetDownload(options); err == nil {
+			return version, dl, nil
+		}
+	}
+
+	if !found {
+		return nil, ArtifactDownload{}, errors.Wrapf(ErrVersionNotFound, "no release in series '%s' is defined", series)
+	}
+
+	return nil, ArtifactDownload{}, errors.Wrapf(ErrBuildNotFound, "no release in series '%s' has a build with options %s", series, options)
+}
+
+// replaceArchiveVersion replaces the last occurrence of the version in
+// the file name of an archive URL.
+func replaceArchiveVersion(url, version, replacement string) (string, error) {
+	idx := strings.LastIndex(url, "/") + 1
+	dir, name := url[:idx], url[idx:]
+
+	pos := strings.LastIndex(name, version)
+	if pos < 0 {
+		return "", errors.Errorf("archive '%s' does not contain version '%s'", url, version)
 	}
-	return strings.Replace(dl.Archive.URL, version.Version, "v"+series+"-latest", -1), nil
+
+	return dir + name[:pos] + replacement + name[pos+len(version):], nil
 }
 
 // GetCurrentArchive is a helper to download the latest stable release for a specific series.
 func (feed *ArtifactsFeed) GetCurrentArchive(series string, options BuildOptions) (string, error) {
-	feed.mutex.RLock()
-	defer feed.mutex.RUnlock()
-
 	version, err := feed.GetLatestRelease(series)
 	if err != nil {
 		return "", errors.Wrapf(err, "finding version for series '%s' ", series)
@@ -180,7 +305,6 @@ func (feed *ArtifactsFeed) GetCurrentArchive(series string, options BuildOptions
 	}
 
 	return dl.Archive.URL, nil
-
 }
 
 // GetLatestRelease returns the latest official release for a specific series.
@@ -190,24 +314,130 @@ func (feed *ArtifactsFeed) GetLatestRelease(series string) (*ArtifactVersion, er
 	if series == "2.4" {
 		version, ok := feed.GetVersion("2.4.14")
 		if !ok {
-			return nil, errors.Errorf("could not find current version 2.4.14")
+			return nil, errors.Wrap(ErrNoCurrentRelease, "could not find current version 2.4.14")
 		}
 		return version, nil
 	}
 
-	for _, version := range feed.Versions {
+	for _, version := range feed.data().versions {
 		if version.Current && strings.HasPrefix(version.Version, series) {
 			return version, nil
 		}
 	}
 
-	return nil, errors.Errorf("could not find a current version for series '%s'", series)
+	return nil, errors.Wrapf(ErrNoCurrentRelease, "could not find a current version for series '%s'", series)
+}
+
+// GetReleaseDownload resolves a release, either a specific version
+// or a series with a "-current" or "-stable" suffix, and returns the
+// version and the download in that version matching the build
+// options. Nightly ("latest") releases are not part of the feed, and
+// cannot be resolved.
+func (feed *ArtifactsFeed) GetReleaseDownload(release string, options BuildOptions) (*ArtifactVersion, ArtifactDownload, error) {
+	version, err := feed.resolveRelease(release)
+	if err != nil {
+		return nil, ArtifactDownload{}, err
+	}
+
+	dl, err := version.GetDownload(options)
+	if err != nil {
+		return nil, ArtifactDownload{}, err
+	}
+
+	return version, dl, nil
+}
+
+// resolveRelease returns the version for a release, which is either a
+// specific version or a series with a "-current" or "-stable" suffix.
+func (feed *ArtifactsFeed) resolveRelease(release string) (*ArtifactVersion, error) {
+	if strings.HasSuffix(release, "-current") || strings.HasSuffix(release, "-stable") {
+		series := strings.Split(release, "-")[0]
+		version, err := feed.GetLatestRelease(series)
+		if err != nil {
+			return nil, errors.Wrapf(err, "finding version for series '%s'", series)
+		}
+		return version, nil
+	}
+
+	if strings.Contains(release, "latest") {
+		return nil, errors.Wrapf(ErrVersionNotFound, "nightly release '%s' is not defined in the feed", release)
+	}
+
+	version, ok := feed.GetVersion(release)
+	if !ok {
+		return nil, errors.Wrapf(ErrVersionNotFound, "no version defined for release '%s'", release)
+	}
+
+	return version, nil
+}
+
+// ArchiveBuild is the archive of a build in the feed. The checksum
+// and git hash are not known for nightly builds, or for debug symbol
+// archives.
+type ArchiveBuild struct {
+	Build   BuildInfo `bson:"build" json:"build" yaml:"build"`
+	URL     string    `bson:"url" json:"url" yaml:"url"`
+	Sha256  string    `bson:"sha256,omitempty" json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	GitHash string    `bson:"githash,omitempty" json:"githash,omitempty" yaml:"githash,omitempty"`
+}
+
+// GetReleaseArchives returns the archives for a release (a version,
+// a series, or a series' "latest" nightly build) with the specified
+// build options. When the options specify a debug build, the result
+// contains both the build's archive and the archive of its debug
+// symbols.
+func (feed *ArtifactsFeed) GetReleaseArchives(release string, options BuildOptions) ([]ArchiveBuild, error) {
+	// this is a series, have to handle it differently
+	hasLatest := strings.Contains(release, "latest")
+	if len(release) == 3 || hasLatest {
+		if hasLatest {
+			release = strings.Split(release, "-")[0]
+		}
+
+		url, err := feed.GetLatestArchive(release, options)
+		if err != nil {
+			return nil, err
+		}
+
+		return []ArchiveBuild{{
+			Build: BuildInfo{Version: coerceSeries(release) + "-latest", Options: options},
+			URL:   url,
+		}}, nil
+	}
+
+	version, dl, err := feed.GetReleaseDownload(release, options)
+	if err != nil {
+		return nil, err
+	}
+
+	build := BuildInfo{Version: version.Version, Options: options}
+	build.Options.Debug = false
+	out := []ArchiveBuild{{
+		Build:   build,
+		URL:     dl.Archive.URL,
+		Sha256:  dl.Archive.Sha256,
+		GitHash: version.GitHash,
+	}}
+
+	if options.Debug {
+		if dl.Archive.Debug == "" {
+			return nil, errors.Wrapf(ErrBuildNotFound, "no debug symbols defined for release '%s' with options %s", version.Version, options)
+		}
+
+		build.Options.Debug = true
+		out = append(out, ArchiveBuild{Build: build, URL: dl.Archive.Debug, GitHash: version.GitHash})
+	}
+
+	return out, nil
 }
 
 // GetArchives provides an iterator for all archives given a list of
 // releases (versions) for a specific set of build operations.
 // Returns channels of urls (strings) and errors. Read from the error channel,
-// after completing all results.
+// after completing all results: it receives at most one error, which
+// combines the errors of every release. When the options specify a debug
+// build, GetArchives produces only the archive of its debug symbols;
+// use GetReleaseArchives for both archives.
 func (feed *ArtifactsFeed) GetArchives(releases []string, options BuildOptions) (<-chan string, <-chan error) {
 	output := make(chan string)
 	errOut := make(chan error)
@@ -215,53 +445,21 @@ func (feed *ArtifactsFeed) GetArchives(releases []string, options BuildOptions)
 	go func() {
 		catcher := grip.NewCatcher()
 		for _, rel := range releases {
-			// this is a series, have to handle it differently
-			hasLatest := strings.Contains(rel, "latest")
-			if len(rel) == 3 || hasLatest {
-				if hasLatest {
-					rel = strings.Split(rel, "-")[0]
-				}
-
-				url, err := feed.GetLatestArchive(rel, options)
-				if err != nil {
-					catcher.Add(err)
-					continue
-				}
-				output <- url
-				continue
-			}
-
-			if strings.HasSuffix(rel, "-current") || strings.HasSuffix(rel, "-stable") {
-				rel = strings.Split(rel, "-")[0]
-				url, err := feed.GetCurrentArchive(rel, options)
-				if err != nil {
-					catcher.Add(err)
-					continue
-				}
-				output <- url
-				continue
-			}
-
-			version, ok := feed.GetVersion(rel)
-			if !ok {
-				catcher.Errorf("no version defined for release '%s'", rel)
-				continue
-			}
-			dl, err := version.GetDownload(options)
+			archives, err := feed.GetReleaseArchives(rel, options)
 			if err != nil {
 				catcher.Add(err)
 				continue
 			}
 
-			if options.Debug {
-				output <- dl.Archive.Debug
-				continue
+			for _, archive := range archives {
+				if archive.Build.Options.Debug == options.Debug {
+					output <- archive.URL
+				}
 			}
-			output <- dl.Archive.URL
 		}
 		close(output)
 		if catcher.HasErrors() {
-			errOut <- catcher.Resolve()
+			errOut <- ResolveErrors(catcher)
 		}
 		close(errOut)
 	}()
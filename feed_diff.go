@@ -0,0 +1,212 @@
+package bond
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldChange records the old and new values of a field that changed
+// between two feeds.
+type FieldChange struct {
+	Field string `bson:"field" json:"field" yaml:"field"`
+	Old   string `bson:"old" json:"old" yaml:"old"`
+	New   string `bson:"new" json:"new" yaml:"new"`
+}
+
+// DownloadChange records the changes to a download that is in both
+// feeds.
+type DownloadChange struct {
+	Platform Platform      `bson:"platform" json:"platform" yaml:"platform"`
+	Changes  []FieldChange `bson:"changes" json:"changes" yaml:"changes"`
+}
+
+// VersionDiff records the changes to a version that is in both feeds.
+type VersionDiff struct {
+	Version          string           `bson:"version" json:"version" yaml:"version"`
+	Changes          []FieldChange    `bson:"changes,omitempty" json:"changes,omitempty" yaml:"changes,omitempty"`
+	AddedDownloads   []Platform       `bson:"added_downloads,omitempty" json:"added_downloads,omitempty" yaml:"added_downloads,omitempty"`
+	RemovedDownloads []Platform       `bson:"removed_downloads,omitempty" json:"removed_downloads,omitempty" yaml:"removed_downloads,omitempty"`
+	ChangedDownloads []DownloadChange `bson:"changed_downloads,omitempty" json:"changed_downloads,omitempty" yaml:"changed_downloads,omitempty"`
+}
+
+// FeedDiff records the differences between two feeds. Versions are
+// ordered from the newest to the oldest.
+type FeedDiff struct {
+	AddedVersions   []string      `bson:"added_versions,omitempty" json:"added_versions,omitempty" yaml:"added_versions,omitempty"`
+	RemovedVersions []string      `bson:"removed_versions,omitempty" json:"removed_versions,omitempty" yaml:"removed_versions,omitempty"`
+	ChangedVersions []VersionDiff `bson:"changed_versions,omitempty" json:"changed_versions,omitempty" yaml:"changed_versions,omitempty"`
+}
+
+// IsEmpty returns true if the feeds are the same.
+func (d *FeedDiff) IsEmpty() bool {
+	return len(d.AddedVersions) == 0 && len(d.RemovedVersions) == 0 && len(d.ChangedVersions) == 0
+}
+
+// DiffFeeds compares two feeds, typically an older cached copy of the
+// feed and a newly populated one. Either feed may be nil, which is
+// treated as an empty feed.
+func DiffFeeds(old, new *ArtifactsFeed) *FeedDiff {
+	return diffFeedSummaries(summarizeFeed(old), summarizeFeed(new))
+}
+
+// FeedHook is called after a feed is populated, with the differences
+// between the feed's previous and current contents.
+type FeedHook func(ctx context.Context, diff *FeedDiff)
+
+// AddHook registers a hook that is called after every successful
+// call to Populate.
+func (feed *ArtifactsFeed) AddHook(hook FeedHook) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	feed.hooks = append(feed.hooks, hook)
+}
+
+func (feed *ArtifactsFeed) runHooks(ctx context.Context, old feedSummary) {
+	feed.mutex.RLock()
+	hooks := make([]FeedHook, len(feed.hooks))
+	copy(hooks, feed.hooks)
+	subscribers := make([]*feedSubscriber, len(feed.subscribers))
+	copy(subscribers, feed.subscribers)
+	feed.mutex.RUnlock()
+
+	if len(hooks) == 0 && len(subscribers) == 0 {
+		return
+	}
+
+	diff := diffFeedSummaries(old, summarizeFeed(feed))
+	for _, hook := range hooks {
+		hook(ctx, diff)
+	}
+
+	if diff.IsEmpty() {
+		return
+	}
+	for _, sub := range subscribers {
+		sub.send(diff)
+	}
+}
+
+// feedSummary holds the fields of a feed that DiffFeeds compares,
+// copied so that the feed can be reloaded after summarizing it.
+type feedSummary map[string]versionSummary
+
+type versionSummary struct {
+	fields    []FieldChange
+	downloads map[Platform][]FieldChange
+}
+
+func summarizeFeed(feed *ArtifactsFeed) feedSummary {
+	out := feedSummary{}
+	if feed == nil {
+		return out
+	}
+
+	for _, version := range feed.data().versions {
+		summary := versionSummary{
+			// only the New values are set in summaries.
+			fields: []FieldChange{
+				{Field: "githash", New: version.GitHash},
+				{Field: "production_release", New: strconv.FormatBool(version.ProductionRelease)},
+				{Field: "development_release", New: strconv.FormatBool(version.DevelopmentRelease)},
+				{Field: "lts_release", New: strconv.FormatBool(version.LTSRelease)},
+				{Field: "continuous_release", New: strconv.FormatBool(version.ContinuousRelease)},
+				{Field: "current", New: strconv.FormatBool(version.Current)},
+			},
+			downloads: map[Platform][]FieldChange{},
+		}
+
+		for _, dl := range version.Downloads {
+			summary.downloads[Platform{Target: dl.Target, Arch: dl.Arch, Edition: dl.Edition}] = []FieldChange{
+				{Field: "url", New: dl.Archive.URL},
+				{Field: "sha1", New: dl.Archive.Sha1},
+				{Field: "sha256", New: dl.Archive.Sha256},
+				{Field: "debug_symbols", New: dl.Archive.Debug},
+				{Field: "msi", New: dl.Msi},
+				{Field: "packages", New: strings.Join(dl.Packages, " ")},
+			}
+		}
+
+		out[version.Version] = summary
+	}
+
+	return out
+}
+
+func diffFeedSummaries(old, new feedSummary) *FeedDiff {
+	diff := &FeedDiff{}
+
+	for name, version := range new {
+		prev, ok := old[name]
+		if !ok {
+			diff.AddedVersions = append(diff.AddedVersions, name)
+			continue
+		}
+
+		vd := VersionDiff{
+			Version: name,
+			Changes: diffFields(prev.fields, version.fields),
+		}
+
+		for p, dl := range version.downloads {
+			prevDl, ok := prev.downloads[p]
+			if !ok {
+				vd.AddedDownloads = append(vd.AddedDownloads, p)
+				continue
+			}
+			if changes := diffFields(prevDl, dl); len(changes) > 0 {
+				vd.ChangedDownloads = append(vd.ChangedDownloads, DownloadChange{Platform: p, Changes: changes})
+			}
+		}
+		for p := range prev.downloads {
+			if _, ok := version.downloads[p]; !ok {
+				vd.RemovedDownloads = append(vd.RemovedDownloads, p)
+			}
+		}
+
+		if len(vd.Changes) == 0 && len(vd.AddedDownloads) == 0 && len(vd.RemovedDownloads) == 0 && len(vd.ChangedDownloads) == 0 {
+			continue
+		}
+
+		sortPlatforms(vd.AddedDownloads)
+		sortPlatforms(vd.RemovedDownloads)
+		sort.Slice(vd.ChangedDownloads, func(i, j int) bool {
+			return platformLess(vd.ChangedDownloads[i].Platform, vd.ChangedDownloads[j].Platform)
+		})
+		diff.ChangedVersions = append(diff.ChangedVersions, vd)
+	}
+
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			diff.RemovedVersions = append(diff.RemovedVersions, name)
+		}
+	}
+
+	sortVersions(diff.AddedVersions)
+	sortVersions(diff.RemovedVersions)
+
+	names := make([]string, 0, len(diff.ChangedVersions))
+	byName := make(map[string]VersionDiff, len(diff.ChangedVersions))
+	for _, vd := range diff.ChangedVersions {
+		names = append(names, vd.Version)
+		byName[vd.Version] = vd
+	}
+	sortVersions(names)
+	for idx, name := range names {
+		diff.ChangedVersions[idx] = byName[name]
+	}
+
+	return diff
+}
+
+func diffFields(old, new []FieldChange) []FieldChange {
+	var out []FieldChange
+	for idx := range new {
+		if old[idx].New != new[idx].New {
+			out = append(out, FieldChange{Field: new[idx].Field, Old: old[idx].New, New: new[idx].New})
+		}
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+package bond
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// updatedTestFeedData changes testFeedData the way that a new
+// release changes the feed: 4.4.2 is added and 4.4.1-rc0 removed,
+// 4.4.1 is no longer current and its rhel80 build is replaced by a
+// rhel82 build, and the checksum of a 4.4.0 build is added.
+var updatedTestFeedData = strings.NewReplacer(
+	`"version": "4.4.1-rc0"`, `"version": "4.4.2"`,
+	`"githash": "ad91a93a5a31e175f5cbf8c69561e788bbc55ce1",
+      "production_release": true,
+      "current": true,`, `"githash": "ad91a93a5a31e175f5cbf8c69561e788bbc55ce1",
+      "production_release": true,`,
+	`"url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.0.tgz"`,
+	`"url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.0.tgz", "sha256": "abcdef"`,
+	`"target": "rhel80",`, `"target": "rhel82",`,
+).Replace(testFeedData)
+
+func TestDiffFeeds(t *testing.T) {
+	old := newTestFeed(t)
+	updated, err := NewArtifactsFeed(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, updated.Reload([]byte(updatedTestFeedData)))
+
+	diff := DiffFeeds(old, updated)
+	assert.False(t, diff.IsEmpty())
+	assert.Equal(t, []string{"4.4.2"}, diff.AddedVersions)
+	assert.Equal(t, []string{"4.4.1-rc0"}, diff.RemovedVersions)
+	require.Len(t, diff.ChangedVersions, 2)
+
+	rhel := func(target string) Platform {
+		return Platform{Target: target, Arch: AMD64, Edition: Enterprise}
+	}
+
+	assert.Equal(t, VersionDiff{
+		Version:          "4.4.1",
+		Changes:          []FieldChange{{Field: "current", Old: "true", New: "false"}},
+		AddedDownloads:   []Platform{rhel("rhel82")},
+		RemovedDownloads: []Platform{rhel("rhel80")},
+	}, diff.ChangedVersions[0])
+	assert.Equal(t, VersionDiff{
+		Version: "4.4.0",
+		ChangedDownloads: []DownloadChange{{
+			Platform: Platform{Target: "linux_x86_64", Arch: AMD64, Edition: Base},
+			Changes:  []FieldChange{{Field: "sha256", Old: "", New: "abcdef"}},
+		}},
+	}, diff.ChangedVersions[1])
+
+	assert.True(t, DiffFeeds(old, old).IsEmpty())
+	assert.Equal(t, []string{"4.4.1", "4.4.1-rc0", "4.4.0", "4.2.10"}, DiffFeeds(nil, old).AddedVersions)
+	assert.Len(t, DiffFeeds(old, nil).RemovedVersions, 4)
+}
+
+func TestFeedPopulateRunsHooks(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "full.json")
+	require.NoError(t, ioutil.WriteFile(fn, []byte(testFeedData), 0644))
+
+	feed, err := NewArtifactsFeed(dir)
+	require.NoError(t, err)
+
+	var diffs []*FeedDiff
+	feed.AddHook(func(_ context.Context, diff *FeedDiff) { diffs = append(diffs, diff) })
+
+	ctx := context.Background()
+	require.NoError(t, feed.Populate(ctx, time.Hour))
+	require.Len(t, diffs, 1)
+	assert.Len(t, diffs[0].AddedVersions, 4)
+
+	require.NoError(t, feed.Populate(ctx, time.Hour))
+	require.Len(t, diffs, 2)
+	assert.True(t, diffs[1].IsEmpty())
+
+	require.NoError(t, ioutil.WriteFile(fn, []byte(updatedTestFeedData), 0644))
+	require.NoError(t, feed.Populate(ctx, time.Hour))
+	require.Len(t, diffs, 3)
+	assert.Equal(t, []string{"4.4.2"}, diffs[2].AddedVersions)
+	assert.Equal(t, []string{"4.4.1-rc0"}, diffs[2].RemovedVersions)
+
+	// fields that are omitted from the new data should not keep
+	// their old values.
+	version, ok := feed.GetVersion("4.4.1")
+	require.True(t, ok)
+	assert.False(t, version.Current)
+}
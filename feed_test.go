@@ -0,0 +1,328 @@
+package bond
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFeedData is a small subset of the structure of the full.json
+// feed, used to test feed operations without network access.
+const testFeedData = `{
+  "versions": [
+    {
+      "version": "4.4.1",
+      "githash": "ad91a93a5a31e175f5cbf8c69561e788bbc55ce1",
+      "production_release": true,
+      "current": true,
+      "downloads": [
+        {
+          "arch": "x86_64",
+          "edition": "base",
+          "target": "linux_x86_64",
+          "archive": {
+            "url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.1.tgz",
+            "sha256": "4a1b8fd4fcd1e0e5a30bb5c4e0c7fcbb0acdbc4e4c8d8b4f4bfc5e0e1a8cb7b6",
+            "debug_symbols": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-debugsymbols-4.4.1.tgz"
+          }
+        },
+        {
+          "arch": "x86_64",
+          "edition": "enterprise",
+          "target": "rhel80",
+          "archive": {
+            "url": "https://downloads.mongodb.com/linux/mongodb-linux-x86_64-enterprise-rhel80-4.4.1.tgz",
+            "sha256": "0f8e1b7b1a6c5e7a9e2e6b0c4c0f1c6f5d2a0b9a1d6c8e1f0b2d3c4e5f6a7b8c",
+            "debug_symbols": "https://downloads.mongodb.com/linux/mongodb-linux-x86_64-enterprise-rhel80-debugsymbols-4.4.1.tgz"
+          },
+          "packages": [
+            "https://repo.mongodb.com/yum/redhat/8/mongodb-enterprise/4.4/x86_64/RPMS/mongodb-enterprise-server-4.4.1-1.el8.x86_64.rpm",
+            "https://repo.mongodb.com/yum/redhat/8/mongodb-enterprise/4.4/x86_64/RPMS/mongodb-enterprise-mongos-4.4.1-1.el8.x86_64.rpm"
+          ]
+        },
+        {
+          "arch": "x86_64",
+          "edition": "targeted",
+          "target": "ubuntu2004",
+          "archive": {
+            "url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2004-4.4.1.tgz",
+            "sha256": "9d1bd2f4a04e7e5e4e9d4c2c1b1e0b7f2b3f6c1d1e8f7a6b5c4d3e2f1a0b9c8d",
+            "debug_symbols": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-ubuntu2004-debugsymbols-4.4.1.tgz"
+          },
+          "packages": [
+            "https://repo.mongodb.org/apt/ubuntu/dists/focal/mongodb-org/4.4/multiverse/binary-amd64/mongodb-org-server_4.4.1_amd64.deb",
+            "https://repo.mongodb.org/apt/ubuntu/dists/focal/mongodb-org/4.4/multiverse/binary-amd64/mongodb-org-mongos_4.4.1_amd64.deb"
+          ]
+        }
+      ]
+    },
+    {
+      "version": "4.4.1-rc0",
+      "githash": "ad91a93a5a31e175f5cbf8c69561e788bbc55ce1",
+      "downloads": [
+        {
+          "arch": "x86_64",
+          "edition": "base",
+          "target": "linux_x86_64",
+          "archive": {
+            "url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.1-rc0.tgz"
+          }
+        }
+      ]
+    },
+    {
+      "version": "4.4.0",
+      "githash": "563487e100c4215e2dce98d0af2a6a5a2d67c5cf",
+      "production_release": true,
+      "downloads": [
+        {
+          "arch": "x86_64",
+          "edition": "base",
+          "target": "linux_x86_64",
+          "archive": {
+            "url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.0.tgz"
+          }
+        },
+        {
+          "arch": "x86_64",
+          "edition": "enterprise",
+          "target": "rhel70",
+          "archive": {
+            "url": "https://downloads.mongodb.com/linux/mongodb-linux-x86_64-enterprise-rhel70-4.4.0.tgz"
+          }
+        }
+      ]
+    },
+    {
+      "version": "4.2.10",
+      "githash": "88276238fa97b47c0ef14362b343c5317ecbd739",
+      "production_release": true,
+      "current": true,
+      "downloads": [
+        {
+          "arch": "x86_64",
+          "edition": "base",
+          "target": "linux_x86_64",
+          "archive": {
+            "url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.2.10.tgz"
+          }
+        }
+      ]
+    }
+  ]
+}`
+
+func newTestFeed(t *testing.T) *ArtifactsFeed {
+	feed, err := NewArtifactsFeed(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, feed.Reload([]byte(testFeedData)))
+	return feed
+}
+
+func TestFeedGetVersionByGitHash(t *testing.T) {
+	assert := assert.New(t)
+	feed := newTestFeed(t)
+
+	version, err := feed.GetVersionByGitHash("563487e100c4215e2dce98d0af2a6a5a2d67c5cf")
+	assert.NoError(err)
+	assert.Equal("4.4.0", version.Version)
+
+	version, err = feed.GetVersionByGitHash("563487E1")
+	assert.NoError(err)
+	assert.Equal("4.4.0", version.Version)
+
+	// a release and its release candidates can share a commit.
+	version, err = feed.GetVersionByGitHash("ad91a93")
+	assert.NoError(err)
+	assert.Equal("4.4.1", version.Version)
+
+	for _, hash := range []string{"", "0000000", "ffff"} {
+		version, err = feed.GetVersionByGitHash(hash)
+		assert.Error(err, hash)
+		assert.Nil(version)
+	}
+}
+
+func TestFeedGetArchivesDebugSymbols(t *testing.T) {
+	assert := assert.New(t)
+	feed := newTestFeed(t)
+
+	urls, errs := feed.GetArchives([]string{"4.4.1", "4.4.0"}, BuildOptions{
+		Target:  "linux",
+		Arch:    AMD64,
+		Edition: Base,
+		Debug:   true,
+	})
+
+	var out []string
+	for url := range urls {
+		out = append(out, url)
+	}
+	var catcher []error
+	for err := range errs {
+		catcher = append(catcher, err)
+	}
+
+	assert.Equal([]string{
+		"https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-debugsymbols-4.4.1.tgz",
+	}, out)
+	// 4.4.0 has no debug symbols in the feed.
+	assert.Len(catcher, 1)
+}
+
+func TestFeedGetReleaseDownload(t *testing.T) {
+	assert := assert.New(t)
+	feed := newTestFeed(t)
+	opts := BuildOptions{Target: "ubuntu2004", Arch: AMD64, Edition: CommunityTargeted}
+
+	version, dl, err := feed.GetReleaseDownload("4.4.1", opts)
+	assert.NoError(err)
+	assert.Equal("4.4.1", version.Version)
+	assert.Len(dl.GetPackages(), 2)
+
+	version, _, err = feed.GetReleaseDownload("4.4-current", opts)
+	assert.NoError(err)
+	assert.Equal("4.4.1", version.Version)
+
+	for _, rel := range []string{"4.4-latest", "4.4.0", "3.2.11", "3.2-current"} {
+		version, _, err = feed.GetReleaseDownload(rel, opts)
+		assert.Error(err, rel)
+		assert.Nil(version)
+	}
+}
+
+func TestFeedConcurrentReloadAndQueries(t *testing.T) {
+	feed := newTestFeed(t)
+	opts := BuildOptions{Target: "linux", Arch: AMD64, Edition: Base}
+	host := HostInfo{OS: "linux", Arch: AMD64, Distro: DistroInfo{ID: "rhel", VersionID: "8.2"}}
+
+	// old versions must not be modified by reloads.
+	old, ok := feed.GetVersion("4.4.1")
+	require.True(t, ok)
+
+	queries := []func(){
+		func() { _, _ = feed.GetVersion("4.4.1") },
+		func() { _, _ = feed.GetVersionByGitHash("ad91a93") },
+		func() { _, _ = feed.GetLatestArchive("4.4", opts) },
+		func() { _, _ = feed.GetCurrentArchive("4.4", opts) },
+		func() { _, _ = feed.GetLatestRelease("4.4") },
+		func() { _, _, _ = feed.GetReleaseDownload("4.4-current", opts) },
+		func() { _, _ = feed.ResolveTarget("4.4.1", host.Distro, AMD64, Enterprise) },
+		func() { _, _ = feed.SelectBuild("4.4.1", host) },
+		func() { _ = feed.GetCompatibilityMatrix(MatrixOptions{}) },
+		func() { _ = DiffFeeds(feed, feed) },
+		func() {
+			for _, version := range feed.GetVersions() {
+				_ = version.GetBuildTypes()
+				_, _ = version.GetDownload(opts)
+			}
+		},
+		func() {
+			urls, errs := feed.GetArchives([]string{"4.4.1", "4.4.0"}, opts)
+			for range urls {
+			}
+			for range errs {
+			}
+		},
+	}
+
+	wg := &sync.WaitGroup{}
+	for idx := 0; idx < 4; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				data := testFeedData
+				if (i+idx)%2 == 0 {
+					data = updatedTestFeedData
+				}
+				assert.NoError(t, feed.Reload([]byte(data)))
+			}
+		}(idx)
+	}
+	for _, query := range queries {
+		wg.Add(1)
+		go func(query func()) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				query()
+			}
+		}(query)
+	}
+	wg.Wait()
+
+	assert.True(t, old.Current)
+	_, err := old.GetDownload(BuildOptions{Target: "rhel80", Arch: AMD64, Edition: Enterprise})
+	assert.NoError(t, err)
+}
+
+func TestFeedGetReleaseArchives(t *testing.T) {
+	assert := assert.New(t)
+	feed := newTestFeed(t)
+	opts := BuildOptions{Target: "linux_x86_64", Arch: AMD64, Edition: Base}
+
+	archives, err := feed.GetReleaseArchives("4.4.1", opts)
+	assert.NoError(err)
+	assert.Equal([]ArchiveBuild{{
+		Build:   BuildInfo{Version: "4.4.1", Options: opts},
+		URL:     "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.1.tgz",
+		Sha256:  "4a1b8fd4fcd1e0e5a30bb5c4e0c7fcbb0acdbc4e4c8d8b4f4bfc5e0e1a8cb7b6",
+		GitHash: "ad91a93a5a31e175f5cbf8c69561e788bbc55ce1",
+	}}, archives)
+
+	debug := opts
+	debug.Debug = true
+	archives, err = feed.GetReleaseArchives("4.4.1", debug)
+	assert.NoError(err)
+	if assert.Len(archives, 2) {
+		assert.Equal(BuildInfo{Version: "4.4.1", Options: opts}, archives[0].Build)
+		assert.Equal(BuildInfo{Version: "4.4.1", Options: debug}, archives[1].Build)
+		assert.Equal("https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-debugsymbols-4.4.1.tgz", archives[1].URL)
+	}
+
+	archives, err = feed.GetReleaseArchives("4.4-latest", opts)
+	assert.NoError(err)
+	if assert.Len(archives, 1) {
+		assert.Equal("4.4-latest", archives[0].Build.Version)
+		assert.Equal("https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-v4.4-latest.tgz", archives[0].URL)
+	}
+
+	for _, rel := range []string{"3.2.11", "4.6"} {
+		archives, err = feed.GetReleaseArchives(rel, opts)
+		assert.Error(err, rel)
+		assert.Nil(archives)
+	}
+}
+
+func TestFeedGetLatestArchive(t *testing.T) {
+	assert := assert.New(t)
+	feed, err := NewArtifactsFeed(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, feed.Reload([]byte(`{"versions": [
+  {"version": "3.5.1", "downloads": [{"arch": "x86_64", "edition": "targeted", "target": "rhel80", "archive": {"url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-rhel80-3.5.1.tgz"}}]},
+  {"version": "6.0.5", "downloads": [{"arch": "x86_64", "edition": "targeted", "target": "rhel80", "archive": {"url": "https://example.net/6.0.5/mongodb-linux-x86_64-rhel80-6.0.5.tgz"}}]},
+  {"version": "6.0.4", "downloads": [{"arch": "x86_64", "edition": "targeted", "target": "rhel80", "archive": {"url": "https://example.net/6.0.4/mongodb-linux-x86_64-rhel80-6.0.4.tgz"}}]}
+]}`)))
+	opts := BuildOptions{Target: "rhel80", Arch: AMD64, Edition: CommunityTargeted}
+
+	// without a .0 release, the most recent release in the series
+	// is used, and only the file name is changed.
+	url, err := feed.GetLatestArchive("6.0", opts)
+	assert.NoError(err)
+	assert.Equal("https://example.net/6.0.5/mongodb-linux-x86_64-rhel80-v6.0-latest.tgz", url)
+
+	// development series don't have a branch.
+	url, err = feed.GetLatestArchive("3.5", opts)
+	assert.NoError(err)
+	assert.Equal("https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-rhel80-latest.tgz", url)
+
+	for _, series := range []string{"5.0", "6.2"} {
+		_, err = feed.GetLatestArchive(series, opts)
+		assert.Error(err, series)
+	}
+
+	_, err = feed.GetLatestArchive("6.0", BuildOptions{Target: "ubuntu2204", Arch: AMD64, Edition: CommunityTargeted})
+	assert.Error(err)
+}
@@ -2,6 +2,7 @@ package bond
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
 	"github.com/pkg/errors"
 )
 
@@ -33,7 +35,18 @@ func createDirectory(ctx context.Context, path string) error {
 // file already exists CacheDownload does not download a new copy of
 // the file, unless local file is older than the ttl, or the force
 // option is specified. CacheDownload returns the contents of the file.
+//
+// In offline mode, CacheDownload always returns the existing file,
+// and returns an *OfflineError if it does not exist.
 func CacheDownload(ctx context.Context, ttl time.Duration, url, path string, force bool) ([]byte, error) {
+	if IsOffline(ctx) {
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil, &OfflineError{URL: url, Path: path}
+		}
+		return data, errors.Wrapf(err, "reading cached file '%s'", path)
+	}
+
 	if ttl == 0 {
 		force = true
 	}
@@ -67,8 +80,18 @@ func CacheDownload(ctx context.Context, ttl time.Duration, url, path string, for
 }
 
 // DownloadFile downloads a resource (url) into a file specified by
-// fileName. Also creates enclosing directories as needed.
+// fileName. Also creates enclosing directories as needed. The
+// download uses the context's HTTP client, if it has one (see
+// WithHTTPClient), and reports its progress to the context's
+// ProgressReporter. The download waits for the context's bandwidth
+// and host limiters, if it has them. Failed requests and error
+// responses return a *DownloadError. In offline mode, DownloadFile
+// returns an *OfflineError.
 func DownloadFile(ctx context.Context, url, fileName string) error {
+	if IsOffline(ctx) {
+		return &OfflineError{URL: url, Path: fileName}
+	}
+
 	if err := createDirectory(ctx, filepath.Dir(fileName)); err != nil {
 		return errors.Wrapf(err, "creating enclosing directory for file '%s'", fileName)
 	}
@@ -81,35 +104,183 @@ func DownloadFile(ctx context.Context, url, fileName string) error {
 	if err != nil {
 		return errors.Wrap(err, "building request")
 	}
+
+	_, err = download(ctx, req.WithContext(ctx), fileName)
+	return err
+}
+
+// RefreshDownload downloads a resource (url) into a file (path) if
+// the file does not exist, or if the resource has changed since the
+// file was downloaded, and returns true if it downloaded the file.
+// Changes are detected with conditional requests that use the ETag
+// and Last-Modified headers of the previous download. The existing
+// file is only replaced once the new copy is completely downloaded.
+// The force option downloads the file unconditionally.
+//
+// In offline mode, RefreshDownload uses the existing file, and
+// returns an *OfflineError if it does not exist.
+func RefreshDownload(ctx context.Context, url, path string, force bool) (bool, error) {
+	_, err := os.Stat(path)
+	exists := err == nil
+
+	if IsOffline(ctx) {
+		if exists {
+			return false, nil
+		}
+		return false, &OfflineError{URL: url, Path: path}
+	}
+
+	if err = createDirectory(ctx, filepath.Dir(path)); err != nil {
+		return false, errors.Wrapf(err, "creating enclosing directory for file '%s'", path)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "building request")
+	}
 	req = req.WithContext(ctx)
 
-	output, err := os.Create(fileName)
+	if exists && !force {
+		if validators, err := readDownloadValidators(path); err == nil && validators.URL == url {
+			if validators.ETag != "" {
+				req.Header.Set("If-None-Match", validators.ETag)
+			}
+			if validators.LastModified != "" {
+				req.Header.Set("If-Modified-Since", validators.LastModified)
+			}
+		}
+	}
+
+	resp, err := download(ctx, req, path)
 	if err != nil {
-		return errors.Wrapf(err, "creating file for package '%s'", fileName)
+		return false, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		grip.Debugf(ctx, "file '%s' has not changed", path)
+		return false, nil
 	}
-	defer output.Close()
 
-	client := GetHTTPClient()
-	defer PutHTTPClient(client)
+	grip.Warning(ctx, message.WrapError(writeDownloadValidators(path, downloadValidators{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}), message.Fields{
+		"op":   "recording download validators",
+		"file": path,
+	}))
+
+	return true, nil
+}
+
+// download executes the request and writes the response body into a
+// temporary file, which replaces fileName once the body is completely
+// written. Responses with a 304 (not modified) status are returned
+// without changing the file.
+func download(ctx context.Context, req *http.Request, fileName string) (*http.Response, error) {
+	client, release := getContextHTTPClient(ctx)
+	defer release()
+
+	if limiter := getHostLimiter(ctx); limiter != nil {
+		release, err := limiter.Acquire(ctx, req.URL.Host)
+		if err != nil {
+			return nil, errors.Wrap(err, "downloading file")
+		}
+		defer release()
+	}
 
+	url := req.URL.String()
 	grip.Noticeln(ctx, "downloading:", fileName)
 	resp, err := client.Do(req)
 	if err != nil {
-		return errors.Wrap(err, "downloading file")
+		return nil, &DownloadError{URL: url, Err: err}
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+
 	if resp.StatusCode >= 300 {
-		grip.Warning(ctx, os.Remove(fileName))
-		return errors.Errorf("received status code %d (%s) for request to URL '%s'", resp.StatusCode, resp.Status, url)
+		return nil, &DownloadError{URL: url, StatusCode: resp.StatusCode}
+	}
+
+	output, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".")
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating file for package '%s'", fileName)
+	}
+
+	var reader io.Reader = resp.Body
+	if limiter := getBandwidthLimiter(ctx); limiter != nil {
+		reader = &limitedReader{ctx: ctx, reader: reader, limiter: limiter}
 	}
 
-	n, err := io.Copy(output, resp.Body)
+	body := newProgressReader(ctx, reader, url, fileName, resp.ContentLength)
+	n, err := io.Copy(output, body)
 	if err != nil {
-		grip.Warning(ctx, os.Remove(fileName))
-		return errors.Wrapf(err, "writing URL '%s' to file '%s'", url, fileName)
+		grip.Warning(ctx, output.Close())
+		grip.Warning(ctx, os.Remove(output.Name()))
+		return nil, errors.Wrapf(&DownloadError{URL: url, StatusCode: resp.StatusCode, Err: err}, "writing to file '%s'", fileName)
+	}
+
+	catcher := grip.NewCatcher()
+	catcher.Add(output.Chmod(0644))
+	catcher.Add(output.Close())
+	catcher.Add(os.Rename(output.Name(), fileName))
+	if catcher.HasErrors() {
+		grip.Warning(ctx, os.Remove(output.Name()))
+		return nil, errors.Wrapf(catcher.Resolve(), "writing file '%s'", fileName)
 	}
+	body.done()
 
 	grip.Debugf(ctx, "%d bytes downloaded. (%s)", n, fileName)
-	return nil
+	return resp, nil
+}
+
+// downloadValidators are the HTTP validators of a downloaded file,
+// which are stored in a hidden file next to it.
+type downloadValidators struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func downloadValidatorsFileName(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".http.json")
+}
+
+// RemoveDownloadValidators removes the hidden file next to a file
+// downloaded with RefreshDownload that records its HTTP validators,
+// if the file has one.
+func RemoveDownloadValidators(path string) error {
+	err := os.Remove(downloadValidatorsFileName(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Wrapf(err, "removing download validators for '%s'", path)
+}
+
+func readDownloadValidators(path string) (downloadValidators, error) {
+	validators := downloadValidators{}
+
+	data, err := ioutil.ReadFile(downloadValidatorsFileName(path))
+	if err != nil {
+		return validators, errors.Wrapf(err, "reading download validators for '%s'", path)
+	}
+
+	if err = json.Unmarshal(data, &validators); err != nil {
+		return validators, errors.Wrapf(err, "parsing download validators for '%s'", path)
+	}
+
+	return validators, nil
+}
+
+func writeDownloadValidators(path string, validators downloadValidators) error {
+	data, err := json.Marshal(validators)
+	if err != nil {
+		return errors.Wrap(err, "converting download validators to JSON")
+	}
+
+	return errors.Wrapf(ioutil.WriteFile(downloadValidatorsFileName(path), data, 0644),
+		"writing download validators for '%s'", path)
 }
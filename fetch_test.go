@@ -1,15 +1,21 @@
 package bond
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mongodb/grip"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
@@ -92,3 +98,107 @@ func (s *DownloaderSuite) TestDownloadFileThatDoesNotExist() {
 	err := DownloadFile(ctx, "http://example.net/DOES_NOT_EXIST", filepath.Join(s.dir, uuid.New().String()))
 	s.Error(err, fmt.Sprintf("%+v", err))
 }
+
+func TestRefreshDownload(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		mutex    sync.Mutex
+		content  = "first"
+		failing  bool
+		statuses []int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if failing {
+			statuses = append(statuses, http.StatusInternalServerError)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if r.Header.Get("If-None-Match") == `"`+content+`"` {
+			statuses = append(statuses, http.StatusNotModified)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		statuses = append(statuses, http.StatusOK)
+		w.Header().Set("ETag", `"`+content+`"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte(content)))
+	}))
+	defer srv.Close()
+
+	set := func(value string, fail bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		content, failing = value, fail
+	}
+	lastStatus := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return statuses[len(statuses)-1]
+	}
+
+	fn := filepath.Join(t.TempDir(), "mongodb-linux-x86_64-v4.4-latest.tgz")
+	url := srv.URL + "/mongodb-linux-x86_64-v4.4-latest.tgz"
+
+	check := func(downloaded bool, status int, expected string) {
+		t.Helper()
+		ok, err := RefreshDownload(ctx, url, fn, false)
+		require.NoError(t, err)
+		assert.Equal(t, downloaded, ok)
+		assert.Equal(t, status, lastStatus())
+
+		data, err := ioutil.ReadFile(fn)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(data))
+	}
+
+	check(true, http.StatusOK, "first")
+	check(false, http.StatusNotModified, "first")
+
+	set("second", false)
+	check(true, http.StatusOK, "second")
+
+	t.Run("FailedDownloadKeepsExistingFile", func(t *testing.T) {
+		set("third", true)
+		_, err := RefreshDownload(ctx, url, fn, false)
+		assert.Error(t, err)
+
+		data, err := ioutil.ReadFile(fn)
+		require.NoError(t, err)
+		assert.Equal(t, "second", string(data))
+
+		// temporary files are removed.
+		contents, err := ioutil.ReadDir(filepath.Dir(fn))
+		require.NoError(t, err)
+		assert.Len(t, contents, 2)
+		set("second", false)
+	})
+	t.Run("Force", func(t *testing.T) {
+		ok, err := RefreshDownload(ctx, url, fn, true)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusOK, lastStatus())
+	})
+	t.Run("Offline", func(t *testing.T) {
+		set("fourth", false)
+		ok, err := RefreshDownload(WithOffline(ctx), url, fn, false)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		_, err = RefreshDownload(WithOffline(ctx), url, fn+".missing", false)
+		assert.True(t, IsOfflineError(err))
+	})
+	t.Run("RemoveValidators", func(t *testing.T) {
+		require.FileExists(t, downloadValidatorsFileName(fn))
+		require.NoError(t, RemoveDownloadValidators(fn))
+		assert.NoFileExists(t, downloadValidatorsFileName(fn))
+		assert.NoError(t, RemoveDownloadValidators(fn))
+
+		// without validators, the file is downloaded again.
+		check(true, http.StatusOK, "fourth")
+	})
+}
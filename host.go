@@ -0,0 +1,323 @@
+package bond
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GetArch returns the MongoDBArch for a Go architecture name, as
+// reported by runtime.GOARCH. See GetPlatformArch for macOS.
+func GetArch(goarch string) (MongoDBArch, error) {
+	switch goarch {
+	case "amd64":
+		return AMD64, nil
+	case "arm64":
+		return ARM64, nil
+	case "ppc64le":
+		return POWER, nil
+	case "s390x":
+		return ZSeries, nil
+	case "386":
+		return X86, nil
+	default:
+		return "", errors.Errorf("architecture '%s' is not supported", goarch)
+	}
+}
+
+// GetPlatformArch returns the MongoDBArch of the builds for a Go
+// operating system and architecture, as reported by runtime.GOOS and
+// runtime.GOARCH. The feed names ARM64 builds for macOS "arm64"
+// rather than "aarch64".
+func GetPlatformArch(goos, goarch string) (MongoDBArch, error) {
+	if goos == "darwin" && goarch == "arm64" {
+		return MacOSARM64, nil
+	}
+	return GetArch(goarch)
+}
+
+// Values for the C library of a host.
+const (
+	LibcGlibc = "glibc"
+	LibcMusl  = "musl"
+)
+
+// HostInfo describes the properties of a host that determine which
+// builds can run on it.
+type HostInfo struct {
+	OS     string      `bson:"os" json:"os" yaml:"os"`
+	Arch   MongoDBArch `bson:"arch" json:"arch" yaml:"arch"`
+	Distro DistroInfo  `bson:"distro" json:"distro" yaml:"distro"`
+	// Libc is the C library of linux hosts, or empty if it isn't
+	// known.
+	Libc string `bson:"libc,omitempty" json:"libc,omitempty" yaml:"libc,omitempty"`
+	// OpenSSL are the major versions of the OpenSSL libraries
+	// installed on the host, e.g. "1.1" or "3".
+	OpenSSL []string `bson:"openssl,omitempty" json:"openssl,omitempty" yaml:"openssl,omitempty"`
+}
+
+// DetectHost inspects the current host.
+func DetectHost(ctx context.Context) (HostInfo, error) {
+	d := &DistroDetector{FS: rootFS("/"), Runner: runHostCommand}
+	return d.DetectHost(ctx)
+}
+
+// DetectHost inspects the system's root filesystem to determine its
+// distro and the libraries available to builds. The operating system
+// and architecture are those of the current process, and can be
+// changed on the returned value when inspecting other systems.
+func (d *DistroDetector) DetectHost(ctx context.Context) (HostInfo, error) {
+	info := HostInfo{OS: runtime.GOOS}
+
+	arch, err := GetPlatformArch(runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	info.Arch = arch
+
+	if info.OS != "linux" {
+		return info, nil
+	}
+
+	distro, err := d.Detect(ctx)
+	if err != nil {
+		return HostInfo{}, errors.Wrap(err, "detecting distro")
+	}
+	info.Distro = distro
+	info.Libc, info.OpenSSL = d.detectLibraries()
+
+	return info, nil
+}
+
+var libraryDirectories = []string{"lib", "lib64", "usr/lib", "usr/lib64", "lib/*-linux-gnu", "usr/lib/*-linux-gnu"}
+
+// opensslLibraries maps the names of libssl shared objects to the
+// OpenSSL versions that they provide. RHEL-family distros use their
+// own names for OpenSSL 1.0.
+var opensslLibraries = map[string]string{
+	"libssl.so.3":      "3",
+	"libssl.so.1.1":    "1.1",
+	"libssl.so.1.0.0":  "1.0",
+	"libssl.so.1.0.2":  "1.0",
+	"libssl.so.10":     "1.0",
+	"libssl.so.1.0.0e": "1.0",
+}
+
+func (d *DistroDetector) detectLibraries() (string, []string) {
+	var libc string
+	seen := map[string]bool{}
+	for _, pattern := range libraryDirectories {
+		dirs, err := fs.Glob(d.FS, pattern)
+		if err != nil {
+			continue
+		}
+
+		for _, dir := range dirs {
+			if matches, _ := fs.Glob(d.FS, dir+"/ld-musl-*.so.1"); len(matches) > 0 && libc == "" {
+				libc = LibcMusl
+			}
+			if _, err := fs.Stat(d.FS, dir+"/libc.so.6"); err == nil {
+				libc = LibcGlibc
+			}
+			for lib, version := range opensslLibraries {
+				if _, err := fs.Stat(d.FS, dir+"/"+lib); err == nil {
+					seen[version] = true
+				}
+			}
+		}
+	}
+
+	var openssl []string
+	for version := range seen {
+		openssl = append(openssl, version)
+	}
+	sort.Strings(openssl)
+
+	return libc, openssl
+}
+
+func (h HostInfo) hasOpenSSL(version string) bool {
+	// if no libraries were found, assume that they couldn't be
+	// detected rather than that they aren't installed.
+	if len(h.OpenSSL) == 0 {
+		return true
+	}
+
+	for _, v := range h.OpenSSL {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// targetOpenSSL maps targets to the OpenSSL version that their builds
+// link against, for targets that are used on newer releases of their
+// distro, where that version of OpenSSL is often missing.
+var targetOpenSSL = map[string]string{
+	"rhel62":     "1.0",
+	"rhel70":     "1.0",
+	"rhel80":     "1.1",
+	"rhel82":     "1.1",
+	"rhel90":     "3",
+	"amazon":     "1.0",
+	"amazon2":    "1.0",
+	"amazon2023": "3",
+	"ubuntu1604": "1.0",
+	"ubuntu1804": "1.1",
+	"ubuntu2004": "1.1",
+	"ubuntu2204": "3",
+	"ubuntu2404": "3",
+	"debian92":   "1.1",
+	"debian10":   "1.1",
+	"debian11":   "1.1",
+	"debian12":   "3",
+	"suse12":     "1.0",
+	"suse15":     "1.1",
+}
+
+// BuildCandidate is a build in the feed that can run on a host.
+type BuildCandidate struct {
+	Version string       `bson:"version" json:"version" yaml:"version"`
+	Options BuildOptions `bson:"options" json:"options" yaml:"options"`
+	Match   TargetMatch  `bson:"match" json:"match" yaml:"match"`
+	Reason  string       `bson:"reason" json:"reason" yaml:"reason"`
+	// MissingLibraries is set when the build depends on a library
+	// that was not found on the host.
+	MissingLibraries bool `bson:"missing_libraries,omitempty" json:"missing_libraries,omitempty" yaml:"missing_libraries,omitempty"`
+}
+
+// BuildSelection is the result of selecting a build for a host: the
+// best build, and the other builds that can run on the host, from
+// the best to the worst.
+type BuildSelection struct {
+	Host         HostInfo         `bson:"host" json:"host" yaml:"host"`
+	Best         BuildCandidate   `bson:"best" json:"best" yaml:"best"`
+	Alternatives []BuildCandidate `bson:"alternatives,omitempty" json:"alternatives,omitempty" yaml:"alternatives,omitempty"`
+}
+
+// SelectHostBuild inspects the current host and selects a build of
+// the release from the feed for it. See SelectBuild.
+func (feed *ArtifactsFeed) SelectHostBuild(ctx context.Context, release string, editions ...MongoDBEdition) (*BuildSelection, error) {
+	host, err := DetectHost(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "inspecting host")
+	}
+
+	return feed.SelectBuild(release, host, editions...)
+}
+
+// SelectBuild selects the best build of a release for a host. The
+// release is either a specific version or a series with a "-current"
+// or "-stable" suffix. Editions are in order of preference, and
+// default to the community targeted and base editions.
+//
+// Every compatible target of each edition is a candidate. Builds are
+// ranked by how closely their target matches the host's distro, then
+// by edition preference. Builds that depend on a version of OpenSSL
+// that isn't installed on the host are ranked last.
+func (feed *ArtifactsFeed) SelectBuild(release string, host HostInfo, editions ...MongoDBEdition) (*BuildSelection, error) {
+	if len(editions) == 0 {
+		editions = []MongoDBEdition{CommunityTargeted, Base}
+	}
+
+	if host.OS == "linux" && host.Libc == LibcMusl {
+		return nil, errors.Wrapf(ErrBuildNotFound, "builds require glibc, which is not available on %s", host.Distro)
+	}
+
+	version, err := feed.resolveRelease(release)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []BuildCandidate
+	for _, edition := range editions {
+		var targets []string
+		for _, dl := range version.Downloads {
+			if dl.Arch == host.Arch && dl.Edition == edition {
+				targets = append(targets, dl.Target)
+			}
+		}
+		if len(targets) == 0 {
+			continue
+		}
+
+		var ranked []TargetResolution
+		if host.OS == "linux" {
+			ranked = host.Distro.RankTargets(targets)
+		} else {
+			ranked = rankPlatformTargets(host.OS, targets)
+		}
+
+		for _, res := range ranked {
+			candidate := BuildCandidate{
+				Version: version.Version,
+				Options: BuildOptions{
+					Target:  res.Target,
+					Arch:    host.Arch,
+					Edition: edition,
+				},
+				Match:  res.Match,
+				Reason: res.Reason,
+			}
+			if required, ok := targetOpenSSL[res.Target]; ok && host.OS == "linux" && !host.hasOpenSSL(required) {
+				candidate.MissingLibraries = true
+				candidate.Reason = fmt.Sprintf("%s; requires OpenSSL %s, which was not found", candidate.Reason, required)
+			}
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, errors.Wrapf(ErrBuildNotFound, "no build of version '%s' for editions %v can run on %s/%s (%s)",
+			version.Version, editions, host.OS, host.Arch, host.Distro)
+	}
+
+	// candidates are already in order of edition preference.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].MissingLibraries != candidates[j].MissingLibraries {
+			return !candidates[i].MissingLibraries
+		}
+		return candidates[i].Match < candidates[j].Match
+	})
+
+	return &BuildSelection{
+		Host:         host,
+		Best:         candidates[0],
+		Alternatives: candidates[1:],
+	}, nil
+}
+
+// rankPlatformTargets returns the targets for a non-linux host, in
+// order of preference.
+func rankPlatformTargets(goos string, targets []string) []TargetResolution {
+	var prefixes []string
+	switch goos {
+	case "darwin":
+		prefixes = []string{"macos", "osx"}
+	case "windows":
+		prefixes = []string{"windows"}
+	default:
+		return nil
+	}
+
+	var ranked []TargetResolution
+	for _, prefix := range prefixes {
+		for _, t := range targets {
+			if strings.HasPrefix(t, prefix) {
+				ranked = append(ranked, TargetResolution{
+					Target: t,
+					Match:  TargetMatchGeneric,
+					Reason: fmt.Sprintf("'%s' is a build for %s", t, goos),
+				})
+			}
+		}
+	}
+
+	return ranked
+}
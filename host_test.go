@@ -0,0 +1,146 @@
+package bond
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetArch(t *testing.T) {
+	for goarch, arch := range map[string]MongoDBArch{
+		"amd64":   AMD64,
+		"arm64":   ARM64,
+		"ppc64le": POWER,
+		"s390x":   ZSeries,
+		"386":     X86,
+	} {
+		actual, err := GetArch(goarch)
+		assert.NoError(t, err)
+		assert.Equal(t, arch, actual)
+	}
+
+	_, err := GetArch("riscv64")
+	assert.Error(t, err)
+
+	arch, err := GetPlatformArch("darwin", "arm64")
+	assert.NoError(t, err)
+	assert.EqualValues(t, MacOSARM64, arch)
+
+	arch, err = GetPlatformArch("linux", "arm64")
+	assert.NoError(t, err)
+	assert.EqualValues(t, ARM64, arch)
+}
+
+func TestDistroDetectorDetectLibraries(t *testing.T) {
+	libc, openssl := NewDistroDetector(filepath.Join("testdata", "distro", "ubuntu2204")).detectLibraries()
+	assert.Equal(t, LibcGlibc, libc)
+	assert.Equal(t, []string{"3"}, openssl)
+
+	libc, openssl = NewDistroDetector(filepath.Join("testdata", "distro", "alpine3")).detectLibraries()
+	assert.Equal(t, LibcMusl, libc)
+	assert.Empty(t, openssl)
+
+	libc, openssl = NewDistroDetector(filepath.Join("testdata", "distro", "empty")).detectLibraries()
+	assert.Empty(t, libc)
+	assert.Empty(t, openssl)
+}
+
+func TestFeedSelectBuild(t *testing.T) {
+	feed := newTestFeed(t)
+
+	t.Run("ExactTarget", func(t *testing.T) {
+		host := HostInfo{OS: "linux", Arch: AMD64, Distro: DistroInfo{ID: "rhel", VersionID: "8.9"}}
+		selection, err := feed.SelectBuild("4.4.1", host, Enterprise, Base)
+		require.NoError(t, err)
+
+		assert.Equal(t, BuildOptions{Target: "rhel80", Arch: AMD64, Edition: Enterprise}, selection.Best.Options)
+		assert.Equal(t, TargetMatchExact, selection.Best.Match)
+		assert.Equal(t, "4.4.1", selection.Best.Version)
+		require.Len(t, selection.Alternatives, 1)
+		assert.Equal(t, BuildOptions{Target: "linux_x86_64", Arch: AMD64, Edition: Base}, selection.Alternatives[0].Options)
+	})
+	t.Run("DefaultEditions", func(t *testing.T) {
+		host := HostInfo{OS: "linux", Arch: AMD64, Distro: DistroInfo{ID: "ubuntu", VersionID: "20.04"}}
+		selection, err := feed.SelectBuild("4.4-current", host)
+		require.NoError(t, err)
+
+		assert.Equal(t, BuildOptions{Target: "ubuntu2004", Arch: AMD64, Edition: CommunityTargeted}, selection.Best.Options)
+		assert.Len(t, selection.Alternatives, 1)
+	})
+	t.Run("MatchQualityBeforeEditionPreference", func(t *testing.T) {
+		host := HostInfo{OS: "linux", Arch: AMD64, Distro: DistroInfo{ID: "ubuntu", VersionID: "20.04"}}
+		selection, err := feed.SelectBuild("4.4.1", host, Base, CommunityTargeted)
+		require.NoError(t, err)
+
+		assert.Equal(t, MongoDBEdition(CommunityTargeted), selection.Best.Options.Edition)
+		assert.Equal(t, MongoDBEdition(Base), selection.Alternatives[0].Options.Edition)
+	})
+	t.Run("MissingOpenSSL", func(t *testing.T) {
+		host := HostInfo{
+			OS:      "linux",
+			Arch:    AMD64,
+			Distro:  DistroInfo{ID: "ubuntu", VersionID: "22.04"},
+			Libc:    LibcGlibc,
+			OpenSSL: []string{"3"},
+		}
+		selection, err := feed.SelectBuild("4.4.1", host)
+		require.NoError(t, err)
+
+		assert.Equal(t, MongoDBEdition(Base), selection.Best.Options.Edition)
+		require.Len(t, selection.Alternatives, 1)
+		assert.Equal(t, "ubuntu2004", selection.Alternatives[0].Options.Target)
+		assert.True(t, selection.Alternatives[0].MissingLibraries)
+		assert.Contains(t, selection.Alternatives[0].Reason, "OpenSSL 1.1")
+	})
+	t.Run("RanksEveryTarget", func(t *testing.T) {
+		feed, err := NewArtifactsFeed(t.TempDir())
+		require.NoError(t, err)
+		require.NoError(t, feed.Reload([]byte(`{"versions": [{"version": "6.0.5", "downloads": [
+  {"arch": "aarch64", "edition": "enterprise", "target": "rhel90", "archive": {"url": "https://example.net/mongodb-linux-aarch64-enterprise-rhel90-6.0.5.tgz"}},
+  {"arch": "aarch64", "edition": "enterprise", "target": "rhel82", "archive": {"url": "https://example.net/mongodb-linux-aarch64-enterprise-rhel82-6.0.5.tgz"}},
+  {"arch": "aarch64", "edition": "enterprise", "target": "rhel80", "archive": {"url": "https://example.net/mongodb-linux-aarch64-enterprise-rhel80-6.0.5.tgz"}},
+  {"arch": "arm64", "edition": "enterprise", "target": "macos", "archive": {"url": "https://example.net/mongodb-macos-arm64-enterprise-6.0.5.tgz"}}
+]}]}`)))
+
+		// the exact target's OpenSSL is missing, so the builds for
+		// the older release are the best and the next best.
+		host := HostInfo{
+			OS:      "linux",
+			Arch:    ARM64,
+			Distro:  DistroInfo{ID: "rhel", VersionID: "9.2"},
+			Libc:    LibcGlibc,
+			OpenSSL: []string{"1.1"},
+		}
+		selection, err := feed.SelectBuild("6.0.5", host, Enterprise)
+		require.NoError(t, err)
+		assert.Equal(t, "rhel82", selection.Best.Options.Target)
+		require.Len(t, selection.Alternatives, 2)
+		assert.Equal(t, "rhel80", selection.Alternatives[0].Options.Target)
+		assert.False(t, selection.Alternatives[0].MissingLibraries)
+		assert.Equal(t, "rhel90", selection.Alternatives[1].Options.Target)
+		assert.True(t, selection.Alternatives[1].MissingLibraries)
+
+		host = HostInfo{OS: "darwin", Arch: MacOSARM64}
+		selection, err = feed.SelectBuild("6.0.5", host, Enterprise)
+		require.NoError(t, err)
+		assert.Equal(t, BuildOptions{Target: "macos", Arch: MacOSARM64, Edition: Enterprise}, selection.Best.Options)
+		assert.Empty(t, selection.Alternatives)
+	})
+	t.Run("Musl", func(t *testing.T) {
+		host := HostInfo{OS: "linux", Arch: AMD64, Distro: DistroInfo{ID: "alpine", VersionID: "3.19.1"}, Libc: LibcMusl}
+		_, err := feed.SelectBuild("4.4.1", host)
+		assert.Error(t, err)
+	})
+	t.Run("NoBuildForArch", func(t *testing.T) {
+		host := HostInfo{OS: "linux", Arch: ARM64, Distro: DistroInfo{ID: "ubuntu", VersionID: "20.04"}}
+		_, err := feed.SelectBuild("4.4.1", host)
+		assert.Error(t, err)
+	})
+	t.Run("UnknownRelease", func(t *testing.T) {
+		host := HostInfo{OS: "linux", Arch: AMD64, Distro: DistroInfo{ID: "ubuntu", VersionID: "20.04"}}
+		_, err := feed.SelectBuild("3.2.0", host)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,158 @@
+package bond
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// limitedReadSize is the largest read from a download body between
+// waits on a bandwidth limiter, so that downloads that share a
+// limiter progress evenly.
+const limitedReadSize = 32 * 1024
+
+// BandwidthLimiter limits the combined rate of the downloads that
+// share it. It's safe for concurrent use.
+type BandwidthLimiter struct {
+	rate   float64
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidthLimiter creates a limiter that allows bytesPerSecond
+// bytes per second, with bursts of up to one second of data.
+func NewBandwidthLimiter(bytesPerSecond int64) (*BandwidthLimiter, error) {
+	if bytesPerSecond <= 0 {
+		return nil, errors.Errorf("bandwidth limit must be positive, not %d", bytesPerSecond)
+	}
+
+	return &BandwidthLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}, nil
+}
+
+// Wait blocks until n more bytes may be transferred, or the context
+// is canceled.
+func (l *BandwidthLimiter) Wait(ctx context.Context, n int) error {
+	l.mutex.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	// transfers larger than the available tokens borrow from the
+	// future, and wait until the debt is repaid.
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mutex.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// HostLimiter limits the number of concurrent connections to each
+// host. It's safe for concurrent use.
+type HostLimiter struct {
+	max   int
+	mutex sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// NewHostLimiter creates a limiter that allows max concurrent
+// connections to each host.
+func NewHostLimiter(max int) (*HostLimiter, error) {
+	if max <= 0 {
+		return nil, errors.Errorf("connections per host must be positive, not %d", max)
+	}
+
+	return &HostLimiter{
+		max:   max,
+		hosts: map[string]chan struct{}{},
+	}, nil
+}
+
+// Acquire blocks until a connection to the host is available, or the
+// context is canceled. The returned function releases the
+// connection.
+func (l *HostLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	l.mutex.Lock()
+	sem, ok := l.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, l.max)
+		l.hosts[host] = sem
+	}
+	l.mutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "waiting for a connection to '%s'", host)
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	}
+}
+
+type bandwidthLimiterContextKey struct{}
+
+type hostLimiterContextKey struct{}
+
+// WithBandwidthLimiter returns a context that limits the rate of the
+// downloads that use it.
+func WithBandwidthLimiter(ctx context.Context, limiter *BandwidthLimiter) context.Context {
+	return context.WithValue(ctx, bandwidthLimiterContextKey{}, limiter)
+}
+
+// WithHostLimiter returns a context that limits the concurrent
+// connections of the downloads that use it.
+func WithHostLimiter(ctx context.Context, limiter *HostLimiter) context.Context {
+	return context.WithValue(ctx, hostLimiterContextKey{}, limiter)
+}
+
+func getBandwidthLimiter(ctx context.Context) *BandwidthLimiter {
+	limiter, _ := ctx.Value(bandwidthLimiterContextKey{}).(*BandwidthLimiter)
+	return limiter
+}
+
+func getHostLimiter(ctx context.Context) *HostLimiter {
+	limiter, _ := ctx.Value(hostLimiterContextKey{}).(*HostLimiter)
+	return limiter
+}
+
+// limitedReader waits on a bandwidth limiter for the data it reads.
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *BandwidthLimiter
+}
+
+func (r *limitedReader) Read(buf []byte) (int, error) {
+	if len(buf) > limitedReadSize {
+		buf = buf[:limitedReadSize]
+	}
+
+	n, err := r.reader.Read(buf)
+	if n > 0 {
+		if waitErr := r.limiter.Wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
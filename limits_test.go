@@ -0,0 +1,100 @@
+package bond
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthLimiter(t *testing.T) {
+	_, err := NewBandwidthLimiter(0)
+	assert.Error(t, err)
+
+	limiter, err := NewBandwidthLimiter(64 * 1024)
+	require.NoError(t, err)
+
+	data := make([]byte, 128*1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	// the first second of data is allowed immediately, and the
+	// rest is limited.
+	start := time.Now()
+	ctx := WithBandwidthLimiter(context.Background(), limiter)
+	require.NoError(t, DownloadFile(ctx, srv.URL, filepath.Join(t.TempDir(), "out")))
+	assert.True(t, time.Since(start) > 800*time.Millisecond, time.Since(start).String())
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, limiter.Wait(canceled, 64*1024))
+}
+
+func TestHostLimiter(t *testing.T) {
+	_, err := NewHostLimiter(0)
+	assert.Error(t, err)
+
+	limiter, err := NewHostLimiter(1)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	release, err := limiter.Acquire(ctx, "a.example.com")
+	require.NoError(t, err)
+
+	// other hosts are not limited.
+	releaseOther, err := limiter.Acquire(ctx, "b.example.com")
+	require.NoError(t, err)
+	releaseOther()
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = limiter.Acquire(timeout, "a.example.com")
+	assert.Error(t, err)
+
+	release()
+	release, err = limiter.Acquire(ctx, "a.example.com")
+	require.NoError(t, err)
+	release()
+}
+
+func TestDownloadFileHostLimit(t *testing.T) {
+	var current, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	limiter, err := NewHostLimiter(2)
+	require.NoError(t, err)
+	ctx := WithHostLimiter(context.Background(), limiter)
+	dir := t.TempDir()
+
+	wg := &sync.WaitGroup{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			assert.NoError(t, DownloadFile(ctx, srv.URL+"/"+name, filepath.Join(dir, name)))
+		}(name)
+	}
+	wg.Wait()
+
+	assert.True(t, atomic.LoadInt32(&peak) <= 2)
+}
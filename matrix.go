@@ -0,0 +1,325 @@
+package bond
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Platform is a combination of target, architecture and edition that
+// builds are produced for.
+type Platform struct {
+	Target  string         `bson:"target" json:"target" yaml:"target"`
+	Arch    MongoDBArch    `bson:"arch" json:"arch" yaml:"arch"`
+	Edition MongoDBEdition `bson:"edition" json:"edition" yaml:"edition"`
+}
+
+func (p Platform) String() string {
+	return fmt.Sprintf("%s-%s-%s", p.Target, p.Arch, p.Edition)
+}
+
+// MatrixOptions filters the contents of a CompatibilityMatrix. Empty
+// fields do not filter.
+type MatrixOptions struct {
+	// Versions are specific versions (e.g. "4.4.1") or release
+	// series (e.g. "4.4").
+	Versions []string         `bson:"versions,omitempty" json:"versions,omitempty" yaml:"versions,omitempty"`
+	Targets  []string         `bson:"targets,omitempty" json:"targets,omitempty" yaml:"targets,omitempty"`
+	Arches   []MongoDBArch    `bson:"arches,omitempty" json:"arches,omitempty" yaml:"arches,omitempty"`
+	Editions []MongoDBEdition `bson:"editions,omitempty" json:"editions,omitempty" yaml:"editions,omitempty"`
+	// ReleasesOnly excludes versions that aren't production
+	// releases, such as release candidates.
+	ReleasesOnly bool `bson:"releases_only" json:"releases_only" yaml:"releases_only"`
+}
+
+func (o MatrixOptions) includesVersion(version *ArtifactVersion) bool {
+	if o.ReleasesOnly && !version.ProductionRelease {
+		return false
+	}
+	if len(o.Versions) == 0 {
+		return true
+	}
+
+	for _, v := range o.Versions {
+		if version.Version == v || strings.HasPrefix(version.Version, v+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (o MatrixOptions) includesPlatform(p Platform) bool {
+	if len(o.Targets) > 0 && !containsString(o.Targets, p.Target) {
+		return false
+	}
+
+	if len(o.Arches) > 0 {
+		found := false
+		for _, arch := range o.Arches {
+			found = found || arch == p.Arch
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(o.Editions) > 0 {
+		found := false
+		for _, edition := range o.Editions {
+			found = found || edition == p.Edition
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CompatibilityMatrix records which platforms have builds for each
+// version in the feed.
+type CompatibilityMatrix struct {
+	// Versions are ordered from the newest to the oldest.
+	Versions []string `bson:"versions" json:"versions" yaml:"versions"`
+	// Platforms are ordered by target, architecture and edition.
+	Platforms []Platform `bson:"platforms" json:"platforms" yaml:"platforms"`
+	// Builds maps each version to the platforms it has builds for.
+	Builds map[string][]Platform `bson:"builds" json:"builds" yaml:"builds"`
+
+	index map[string]map[Platform]bool
+}
+
+// GetCompatibilityMatrix builds a matrix of the versions and platforms
+// in the feed that match the options.
+func (feed *ArtifactsFeed) GetCompatibilityMatrix(opts MatrixOptions) *CompatibilityMatrix {
+	versions := feed.data().versions
+
+	m := &CompatibilityMatrix{
+		Builds: map[string][]Platform{},
+		index:  map[string]map[Platform]bool{},
+	}
+	seen := map[Platform]bool{}
+
+	for _, version := range versions {
+		if !opts.includesVersion(version) {
+			continue
+		}
+		if _, ok := m.index[version.Version]; ok {
+			continue
+		}
+
+		platforms := map[Platform]bool{}
+		for _, dl := range version.Downloads {
+			p := Platform{Target: dl.Target, Arch: dl.Arch, Edition: dl.Edition}
+			if dl.Edition == "source" || platforms[p] || !opts.includesPlatform(p) {
+				continue
+			}
+
+			platforms[p] = true
+			m.Builds[version.Version] = append(m.Builds[version.Version], p)
+			if !seen[p] {
+				seen[p] = true
+				m.Platforms = append(m.Platforms, p)
+			}
+		}
+
+		m.index[version.Version] = platforms
+		m.Versions = append(m.Versions, version.Version)
+		sortPlatforms(m.Builds[version.Version])
+	}
+
+	sortVersions(m.Versions)
+	sortPlatforms(m.Platforms)
+
+	return m
+}
+
+// sortVersions orders versions from the newest to the oldest. Versions
+// that can't be parsed are ordered last.
+func sortVersions(versions []string) {
+	parsed := make(map[string]MongoDBVersion, len(versions))
+	for _, v := range versions {
+		if pv, err := CreateMongoDBVersion(v); err == nil {
+			parsed[v] = pv
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, aok := parsed[versions[i]]
+		b, bok := parsed[versions[j]]
+		if aok && bok {
+			return a.IsGreaterThan(b)
+		}
+		return aok && !bok
+	})
+}
+
+func sortPlatforms(platforms []Platform) {
+	sort.Slice(platforms, func(i, j int) bool { return platformLess(platforms[i], platforms[j]) })
+}
+
+func platformLess(a, b Platform) bool {
+	if a.Target != b.Target {
+		return a.Target < b.Target
+	}
+	if a.Arch != b.Arch {
+		return a.Arch < b.Arch
+	}
+	return a.Edition < b.Edition
+}
+
+// Has returns true if the version has a build for the platform.
+func (m *CompatibilityMatrix) Has(version string, p Platform) bool {
+	if m.index == nil {
+		// matrices decoded from JSON or YAML don't have an index.
+		for _, build := range m.Builds[version] {
+			if build == p {
+				return true
+			}
+		}
+		return false
+	}
+
+	return m.index[version][p]
+}
+
+// GetVersions returns the versions that have builds for a platform,
+// from the newest to the oldest.
+func (m *CompatibilityMatrix) GetVersions(p Platform) []string {
+	var out []string
+	for _, v := range m.Versions {
+		if m.Has(v, p) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// DroppedPlatform describes a platform that doesn't have builds for
+// the newest versions in a matrix.
+type DroppedPlatform struct {
+	Platform Platform `bson:"platform" json:"platform" yaml:"platform"`
+	// LastVersion is the newest version with a build for the
+	// platform.
+	LastVersion string `bson:"last_version" json:"last_version" yaml:"last_version"`
+	// FirstMissing is the oldest version newer than LastVersion
+	// without a build for the platform.
+	FirstMissing string `bson:"first_missing" json:"first_missing" yaml:"first_missing"`
+}
+
+// Dropped returns the platforms that have builds for some version in
+// the matrix, but not for the newest version.
+func (m *CompatibilityMatrix) Dropped() []DroppedPlatform {
+	var out []DroppedPlatform
+	for _, p := range m.Platforms {
+		for idx, v := range m.Versions {
+			if !m.Has(v, p) {
+				continue
+			}
+			if idx > 0 {
+				out = append(out, DroppedPlatform{
+					Platform:     p,
+					LastVersion:  v,
+					FirstMissing: m.Versions[idx-1],
+				})
+			}
+			break
+		}
+	}
+
+	return out
+}
+
+// Matrix output formats.
+const (
+	MatrixFormatJSON     = "json"
+	MatrixFormatCSV      = "csv"
+	MatrixFormatMarkdown = "markdown"
+)
+
+// Write writes the matrix to w in the given format.
+func (m *CompatibilityMatrix) Write(w io.Writer, format string) error {
+	switch format {
+	case MatrixFormatJSON:
+		return m.WriteJSON(w)
+	case MatrixFormatCSV:
+		return m.WriteCSV(w)
+	case MatrixFormatMarkdown, "md":
+		return m.WriteMarkdown(w)
+	default:
+		return errors.Errorf("'%s' is not a supported matrix format", format)
+	}
+}
+
+// WriteJSON writes the matrix as a JSON document.
+func (m *CompatibilityMatrix) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(m), "writing matrix")
+}
+
+// WriteCSV writes the matrix with one row for each version and
+// platform, and a column that records whether there is a build.
+func (m *CompatibilityMatrix) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"version", "target", "arch", "edition", "available"}); err != nil {
+		return errors.Wrap(err, "writing header")
+	}
+
+	for _, v := range m.Versions {
+		for _, p := range m.Platforms {
+			if err := cw.Write([]string{v, p.Target, string(p.Arch), string(p.Edition), strconv.FormatBool(m.Has(v, p))}); err != nil {
+				return errors.Wrap(err, "writing row")
+			}
+		}
+	}
+
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "writing matrix")
+}
+
+// WriteMarkdown writes the matrix as a table with a row for each
+// platform and a column for each version.
+func (m *CompatibilityMatrix) WriteMarkdown(w io.Writer) error {
+	var buf strings.Builder
+
+	buf.WriteString("| target | arch | edition |")
+	for _, v := range m.Versions {
+		buf.WriteString(" " + v + " |")
+	}
+	buf.WriteString("\n| --- | --- | --- |")
+	for range m.Versions {
+		buf.WriteString(" :-: |")
+	}
+	buf.WriteString("\n")
+
+	for _, p := range m.Platforms {
+		fmt.Fprintf(&buf, "| %s | %s | %s |", p.Target, p.Arch, p.Edition)
+		for _, v := range m.Versions {
+			if m.Has(v, p) {
+				buf.WriteString(" x |")
+			} else {
+				buf.WriteString("   |")
+			}
+		}
+		buf.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, buf.String())
+	return errors.Wrap(err, "writing matrix")
+}
@@ -0,0 +1,91 @@
+package bond
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedGetCompatibilityMatrix(t *testing.T) {
+	feed := newTestFeed(t)
+	linux := Platform{Target: "linux_x86_64", Arch: AMD64, Edition: Base}
+	rhel80 := Platform{Target: "rhel80", Arch: AMD64, Edition: Enterprise}
+	rhel70 := Platform{Target: "rhel70", Arch: AMD64, Edition: Enterprise}
+
+	t.Run("AllVersions", func(t *testing.T) {
+		m := feed.GetCompatibilityMatrix(MatrixOptions{})
+
+		assert.Equal(t, []string{"4.4.1", "4.4.1-rc0", "4.4.0", "4.2.10"}, m.Versions)
+		assert.Len(t, m.Platforms, 4)
+		assert.True(t, m.Has("4.2.10", linux))
+		assert.True(t, m.Has("4.4.1", rhel80))
+		assert.False(t, m.Has("4.4.0", rhel80))
+		assert.False(t, m.Has("3.2.0", linux))
+		assert.Equal(t, []string{"4.4.0"}, m.GetVersions(rhel70))
+	})
+	t.Run("Filtered", func(t *testing.T) {
+		m := feed.GetCompatibilityMatrix(MatrixOptions{
+			Versions:     []string{"4.4"},
+			Editions:     []MongoDBEdition{Enterprise},
+			ReleasesOnly: true,
+		})
+
+		assert.Equal(t, []string{"4.4.1", "4.4.0"}, m.Versions)
+		assert.Equal(t, []Platform{rhel70, rhel80}, m.Platforms)
+		assert.Equal(t, []Platform{rhel80}, m.Builds["4.4.1"])
+	})
+	t.Run("Dropped", func(t *testing.T) {
+		m := feed.GetCompatibilityMatrix(MatrixOptions{ReleasesOnly: true})
+
+		assert.Equal(t, []DroppedPlatform{{Platform: rhel70, LastVersion: "4.4.0", FirstMissing: "4.4.1"}}, m.Dropped())
+	})
+}
+
+func TestCompatibilityMatrixOutput(t *testing.T) {
+	m := newTestFeed(t).GetCompatibilityMatrix(MatrixOptions{Versions: []string{"4.4.0", "4.2"}})
+
+	t.Run("JSON", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, m.Write(buf, MatrixFormatJSON))
+
+		decoded := &CompatibilityMatrix{}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), decoded))
+		assert.Equal(t, m.Versions, decoded.Versions)
+		assert.Equal(t, m.Platforms, decoded.Platforms)
+		for _, v := range m.Versions {
+			for _, p := range m.Platforms {
+				assert.Equal(t, m.Has(v, p), decoded.Has(v, p))
+			}
+		}
+	})
+	t.Run("CSV", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, m.Write(buf, MatrixFormatCSV))
+
+		assert.Equal(t, strings.Join([]string{
+			"version,target,arch,edition,available",
+			"4.4.0,linux_x86_64,x86_64,base,true",
+			"4.4.0,rhel70,x86_64,enterprise,true",
+			"4.2.10,linux_x86_64,x86_64,base,true",
+			"4.2.10,rhel70,x86_64,enterprise,false",
+		}, "\n")+"\n", buf.String())
+	})
+	t.Run("Markdown", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, m.Write(buf, MatrixFormatMarkdown))
+
+		assert.Equal(t, strings.Join([]string{
+			"| target | arch | edition | 4.4.0 | 4.2.10 |",
+			"| --- | --- | --- | :-: | :-: |",
+			"| linux_x86_64 | x86_64 | base | x | x |",
+			"| rhel70 | x86_64 | enterprise | x |   |",
+		}, "\n")+"\n", buf.String())
+	})
+	t.Run("InvalidFormat", func(t *testing.T) {
+		assert.Error(t, m.Write(&bytes.Buffer{}, "xml"))
+	})
+}
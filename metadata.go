@@ -0,0 +1,153 @@
+package bond
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BuildMetadataFileName is the name of the file, stored in the root of
+// an extracted build, that records information about the build that
+// cannot be derived from the name of the build.
+const BuildMetadataFileName = ".bond-build.json"
+
+const versionCommandTimeout = 30 * time.Second
+
+// Sources of build metadata.
+const (
+	MetadataSourceFeed    = "feed"
+	MetadataSourceBinary  = "mongod"
+	MetadataSourceArchive = "archive"
+)
+
+// describeVersionPattern matches the "git describe" version at the
+// end of the name of a nightly build, e.g. "4.4.19-rc0-5-g8c7cd5b".
+var describeVersionPattern = regexp.MustCompile(`-v?(\d+\.\d+\.\d+(?:-rc\d+)?-\d+-g([0-9a-f]{7,40}))$`)
+
+// BuildMetadata records the version and git hash of a build, as
+// reported by the build itself or by the feed.
+type BuildMetadata struct {
+	Version string `bson:"version" json:"version" yaml:"version"`
+	GitHash string `bson:"git_hash" json:"git_hash" yaml:"git_hash"`
+	Source  string `bson:"source" json:"source" yaml:"source"`
+}
+
+// IsZero returns true when the metadata has no version or git hash.
+func (md BuildMetadata) IsZero() bool { return md.Version == "" && md.GitHash == "" }
+
+// ReadBuildMetadata reads the build metadata file from the root of an
+// extracted build.
+func ReadBuildMetadata(dir string) (BuildMetadata, error) {
+	md := BuildMetadata{}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, BuildMetadataFileName))
+	if err != nil {
+		return md, errors.Wrapf(err, "reading build metadata for '%s'", dir)
+	}
+
+	if err := json.Unmarshal(data, &md); err != nil {
+		return md, errors.Wrapf(err, "parsing build metadata for '%s'", dir)
+	}
+
+	return md, nil
+}
+
+// WriteBuildMetadata writes the build metadata file into the root of
+// an extracted build, replacing any existing metadata.
+func WriteBuildMetadata(dir string, md BuildMetadata) error {
+	data, err := json.MarshalIndent(md, "", "   ")
+	if err != nil {
+		return errors.Wrap(err, "converting build metadata to JSON")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, BuildMetadataFileName), data, 0644); err != nil {
+		return errors.Wrapf(err, "writing build metadata for '%s'", dir)
+	}
+
+	return nil
+}
+
+// CollectBuildMetadata runs "mongod --version" from the extracted
+// build in dir, and returns the version and git hash that the binary
+// reports.
+func CollectBuildMetadata(ctx context.Context, dir string) (BuildMetadata, error) {
+	bin := filepath.Join(dir, "bin", "mongod")
+	if runtime.GOOS == "windows" {
+		bin += ".exe"
+	}
+
+	if _, err := os.Stat(bin); err != nil {
+		return BuildMetadata{}, errors.Wrapf(err, "finding mongod in '%s'", dir)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, versionCommandTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, bin, "--version").Output()
+	if err != nil {
+		return BuildMetadata{}, errors.Wrapf(err, "running '%s --version'", bin)
+	}
+
+	return ParseVersionOutput(string(out))
+}
+
+// ParseVersionOutput parses the output of "mongod --version", which
+// reports the git hash either on a "git version:" line (older
+// versions) or in the "gitVersion" field of the build info document
+// (newer versions).
+func ParseVersionOutput(out string) (BuildMetadata, error) {
+	md := BuildMetadata{Source: MetadataSourceBinary}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "db version v"):
+			md.Version = strings.TrimPrefix(line, "db version v")
+		case strings.HasPrefix(line, "git version:"):
+			md.GitHash = strings.TrimSpace(strings.TrimPrefix(line, "git version:"))
+		case strings.HasPrefix(line, `"gitVersion":`):
+			md.GitHash = strings.Trim(strings.TrimPrefix(line, `"gitVersion":`), ` ",`)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return BuildMetadata{}, errors.Wrap(err, "reading version output")
+	}
+
+	if md.Version == "" || md.GitHash == "" {
+		return BuildMetadata{}, errors.New("version output does not contain a version and git hash")
+	}
+
+	return md, nil
+}
+
+// ParseArchiveRootName parses the name of the root directory in the
+// archive of a nightly build, which is named after the "git describe"
+// version of the build rather than after the archive. For example,
+// "mongodb-linux-x86_64-v4.4-latest.tgz" may contain
+// "mongodb-linux-x86_64-4.4.19-rc0-5-g8c7cd5b". The git hash is
+// abbreviated.
+func ParseArchiveRootName(name string) (BuildMetadata, error) {
+	match := describeVersionPattern.FindStringSubmatch(name)
+	if match == nil {
+		return BuildMetadata{}, errors.Errorf("'%s' does not contain a version and git hash", name)
+	}
+
+	return BuildMetadata{
+		Version: match[1],
+		GitHash: match[2],
+		Source:  MetadataSourceArchive,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package bond
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersionOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	md, err := ParseVersionOutput(`db version v3.2.11
+git version: 009580ad490190ba33d1c6253ebd8d91808923e4
+OpenSSL version: OpenSSL 1.0.1f 6 Jan 2014
+allocator: tcmalloc
+modules: none
+build environment:
+    distarch: x86_64
+    target_arch: x86_64
+`)
+	assert.NoError(err)
+	assert.Equal("3.2.11", md.Version)
+	assert.Equal("009580ad490190ba33d1c6253ebd8d91808923e4", md.GitHash)
+	assert.Equal(MetadataSourceBinary, md.Source)
+
+	md, err = ParseVersionOutput(`db version v4.4.1
+Build Info: {
+    "version": "4.4.1",
+    "gitVersion": "ad91a93a5a31e175f5cbf8c69561e788bbc55ce1",
+    "openSSLVersion": "OpenSSL 1.1.1f  31 Mar 2020",
+    "modules": [],
+    "allocator": "tcmalloc",
+    "environment": {
+        "distmod": "ubuntu2004",
+        "distarch": "x86_64",
+        "target_arch": "x86_64"
+    }
+}
+`)
+	assert.NoError(err)
+	assert.Equal("4.4.1", md.Version)
+	assert.Equal("ad91a93a5a31e175f5cbf8c69561e788bbc55ce1", md.GitHash)
+
+	for _, out := range []string{"", "db version v4.4.1", "mongod: command not found"} {
+		_, err = ParseVersionOutput(out)
+		assert.Error(err)
+	}
+}
+
+func TestBuildMetadataRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := ReadBuildMetadata(dir)
+	assert.Error(t, err)
+
+	md := BuildMetadata{Version: "4.4.1", GitHash: "ad91a93a5a31e175f5cbf8c69561e788bbc55ce1", Source: MetadataSourceBinary}
+	require.NoError(t, WriteBuildMetadata(dir, md))
+
+	out, err := ReadBuildMetadata(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, md, out)
+}
+
+func TestParseArchiveRootName(t *testing.T) {
+	assert := assert.New(t)
+
+	for name, expected := range map[string]BuildMetadata{
+		"mongodb-linux-x86_64-4.4.19-rc0-5-g8c7cd5b":                   {Version: "4.4.19-rc0-5-g8c7cd5b", GitHash: "8c7cd5b"},
+		"mongodb-linux-x86_64-enterprise-rhel80-7.0.2-13-g3a0e5be6c1f": {Version: "7.0.2-13-g3a0e5be6c1f", GitHash: "3a0e5be6c1f"},
+		"mongodb-macos-x86_64-v4.4.19-rc0-5-g8c7cd5b":                  {Version: "4.4.19-rc0-5-g8c7cd5b", GitHash: "8c7cd5b"},
+	} {
+		md, err := ParseArchiveRootName(name)
+		assert.NoError(err, name)
+		expected.Source = MetadataSourceArchive
+		assert.Equal(expected, md, name)
+	}
+
+	for _, name := range []string{
+		"",
+		"mongodb-linux-x86_64-4.4.1",
+		"mongodb-linux-x86_64-v4.4-latest",
+		"mongodb-linux-x86_64-4.4.19-rc0-5-g8c7",
+	} {
+		_, err := ParseArchiveRootName(name)
+		assert.Error(err, name)
+	}
+}
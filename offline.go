@@ -0,0 +1,54 @@
+package bond
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+var offlineMode atomic.Bool
+
+type offlineContextKey struct{}
+
+// SetOffline enables or disables offline mode for the whole
+// process. In offline mode bond never accesses the network: feeds
+// are read from their cached copies regardless of age, and
+// operations that would download a file fail with an *OfflineError.
+func SetOffline(offline bool) { offlineMode.Store(offline) }
+
+// WithOffline returns a context that enables offline mode for the
+// operations that use it, regardless of the global setting.
+func WithOffline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, offlineContextKey{}, true)
+}
+
+// IsOffline returns true if offline mode is enabled globally or for
+// the context.
+func IsOffline(ctx context.Context) bool {
+	if offlineMode.Load() {
+		return true
+	}
+
+	offline, _ := ctx.Value(offlineContextKey{}).(bool)
+	return offline
+}
+
+// OfflineError is returned by operations that would have downloaded
+// a file in offline mode.
+type OfflineError struct {
+	URL  string
+	Path string
+}
+
+func (e *OfflineError) Error() string {
+	return fmt.Sprintf("offline mode: cannot download '%s' to '%s'", e.URL, e.Path)
+}
+
+// IsOfflineError returns true if the error, or any error that it
+// wraps, is an *OfflineError.
+func IsOfflineError(err error) bool {
+	var offline *OfflineError
+	return errors.As(err, &offline)
+}
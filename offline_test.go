@@ -0,0 +1,71 @@
+package bond
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineMode(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, IsOffline(ctx))
+	assert.True(t, IsOffline(WithOffline(ctx)))
+
+	SetOffline(true)
+	assert.True(t, IsOffline(ctx))
+	SetOffline(false)
+	assert.False(t, IsOffline(ctx))
+}
+
+func TestCacheDownloadOffline(t *testing.T) {
+	ctx := WithOffline(context.Background())
+	url := "http://downloads.mongodb.org/full.json"
+
+	t.Run("MissingFile", func(t *testing.T) {
+		fn := filepath.Join(t.TempDir(), "full.json")
+		_, err := CacheDownload(ctx, time.Hour, url, fn, false)
+		require.Error(t, err)
+		assert.True(t, IsOfflineError(err))
+		assert.True(t, IsOfflineError(errors.Wrap(err, "populating feed")))
+		assert.Contains(t, err.Error(), url)
+	})
+	t.Run("StaleFile", func(t *testing.T) {
+		fn := filepath.Join(t.TempDir(), "full.json")
+		require.NoError(t, ioutil.WriteFile(fn, []byte(testFeedData), 0644))
+		old := time.Now().Add(-48 * time.Hour)
+		require.NoError(t, os.Chtimes(fn, old, old))
+
+		data, err := CacheDownload(ctx, time.Hour, url, fn, true)
+		require.NoError(t, err)
+		assert.Equal(t, testFeedData, string(data))
+		_, err = os.Stat(fn)
+		assert.NoError(t, err)
+	})
+	t.Run("Feed", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := GetArtifactsFeed(ctx, dir)
+		assert.True(t, IsOfflineError(err))
+
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "full.json"), []byte(testFeedData), 0644))
+		feed, err := GetArtifactsFeed(ctx, dir)
+		require.NoError(t, err)
+		_, ok := feed.GetVersion("4.4.1")
+		assert.True(t, ok)
+	})
+}
+
+func TestDownloadFileOffline(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "mongodb.tgz")
+	err := DownloadFile(WithOffline(context.Background()), "https://fastdl.mongodb.org/mongodb.tgz", fn)
+	assert.True(t, IsOfflineError(err))
+
+	_, err = os.Stat(fn)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,263 @@
+package bond
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+)
+
+// progressInterval is the minimum interval between the progress
+// reports of a single download.
+const progressInterval = time.Second
+
+// DownloadProgress describes the state of a download.
+type DownloadProgress struct {
+	URL      string `bson:"url" json:"url" yaml:"url"`
+	Path     string `bson:"path" json:"path" yaml:"path"`
+	Received int64  `bson:"received" json:"received" yaml:"received"`
+	// Total is the size of the download, from the response's
+	// Content-Length, or -1 if the size is unknown.
+	Total   int64         `bson:"total" json:"total" yaml:"total"`
+	Elapsed time.Duration `bson:"elapsed" json:"elapsed" yaml:"elapsed"`
+	// Rate is the average rate of the download in bytes per
+	// second.
+	Rate float64 `bson:"rate" json:"rate" yaml:"rate"`
+	// ETA is the estimated time until the download completes, or
+	// zero if it can't be estimated.
+	ETA  time.Duration `bson:"eta" json:"eta" yaml:"eta"`
+	Done bool          `bson:"done" json:"done" yaml:"done"`
+}
+
+func newDownloadProgress(url, path string, received, total int64, elapsed time.Duration) DownloadProgress {
+	p := DownloadProgress{
+		URL:      url,
+		Path:     path,
+		Received: received,
+		Total:    total,
+		Elapsed:  elapsed,
+	}
+
+	if elapsed > 0 {
+		p.Rate = float64(received) / elapsed.Seconds()
+	}
+	if p.Rate > 0 && total > received {
+		p.ETA = time.Duration(float64(total-received) / p.Rate * float64(time.Second))
+	}
+
+	return p
+}
+
+// ProgressReporter receives the progress of downloads. DownloadFile
+// reports the progress of each download at most once a second, and
+// once more when the download is done. Reporters must be safe for
+// concurrent use.
+type ProgressReporter interface {
+	Report(ctx context.Context, progress DownloadProgress)
+}
+
+// ProgressReporterFunc adapts a function to a ProgressReporter.
+type ProgressReporterFunc func(ctx context.Context, progress DownloadProgress)
+
+// Report calls the function.
+func (f ProgressReporterFunc) Report(ctx context.Context, progress DownloadProgress) {
+	f(ctx, progress)
+}
+
+type progressContextKey struct{}
+
+// WithProgressReporter returns a context that makes downloads that
+// use it report their progress to the reporter.
+func WithProgressReporter(ctx context.Context, reporter ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressContextKey{}, reporter)
+}
+
+// GetProgressReporter returns the context's progress reporter, or a
+// reporter that logs progress every 10 seconds if the context does
+// not have one.
+func GetProgressReporter(ctx context.Context) ProgressReporter {
+	if reporter, ok := ctx.Value(progressContextKey{}).(ProgressReporter); ok && reporter != nil {
+		return reporter
+	}
+
+	return defaultProgressReporter
+}
+
+// HasProgressReporter returns true if the context has a progress
+// reporter.
+func HasProgressReporter(ctx context.Context) bool {
+	reporter, ok := ctx.Value(progressContextKey{}).(ProgressReporter)
+	return ok && reporter != nil
+}
+
+var defaultProgressReporter = NewLoggingProgressReporter(10 * time.Second)
+
+// NewLoggingProgressReporter returns a reporter that logs the
+// progress of each download at most once per interval, and when the
+// download is done.
+func NewLoggingProgressReporter(interval time.Duration) ProgressReporter {
+	return &loggingProgressReporter{
+		interval: interval,
+		logged:   map[string]time.Duration{},
+	}
+}
+
+type loggingProgressReporter struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	logged   map[string]time.Duration
+}
+
+func (r *loggingProgressReporter) Report(ctx context.Context, p DownloadProgress) {
+	r.mutex.Lock()
+	last, ok := r.logged[p.Path]
+	if p.Done {
+		delete(r.logged, p.Path)
+	} else if ok && p.Elapsed-last < r.interval {
+		r.mutex.Unlock()
+		return
+	} else {
+		r.logged[p.Path] = p.Elapsed
+	}
+	r.mutex.Unlock()
+
+	msg := message.Fields{
+		"message":  "downloading",
+		"url":      p.URL,
+		"path":     p.Path,
+		"received": p.Received,
+		"elapsed":  p.Elapsed.String(),
+		"rate":     int64(p.Rate),
+		"done":     p.Done,
+	}
+	if p.Total >= 0 {
+		msg["total"] = p.Total
+	}
+	if p.ETA > 0 {
+		msg["eta"] = p.ETA.Round(time.Second).String()
+	}
+
+	grip.Info(ctx, msg)
+}
+
+// ProgressSummary describes the combined progress of several
+// downloads.
+type ProgressSummary struct {
+	Downloads int   `bson:"downloads" json:"downloads" yaml:"downloads"`
+	Completed int   `bson:"completed" json:"completed" yaml:"completed"`
+	Received  int64 `bson:"received" json:"received" yaml:"received"`
+	// Total is the combined size of the downloads, or -1 if the
+	// size of any download is unknown.
+	Total int64         `bson:"total" json:"total" yaml:"total"`
+	Rate  float64       `bson:"rate" json:"rate" yaml:"rate"`
+	ETA   time.Duration `bson:"eta" json:"eta" yaml:"eta"`
+}
+
+// ProgressAggregator is a ProgressReporter that combines the progress
+// of concurrent downloads, and forwards every report to another
+// reporter.
+type ProgressAggregator struct {
+	next      ProgressReporter
+	started   time.Time
+	mutex     sync.Mutex
+	downloads map[string]DownloadProgress
+}
+
+// NewProgressAggregator creates an aggregator that forwards reports
+// to next, which may be nil.
+func NewProgressAggregator(next ProgressReporter) *ProgressAggregator {
+	return &ProgressAggregator{
+		next:      next,
+		started:   time.Now(),
+		downloads: map[string]DownloadProgress{},
+	}
+}
+
+// Report records the progress of a download.
+func (a *ProgressAggregator) Report(ctx context.Context, p DownloadProgress) {
+	a.mutex.Lock()
+	a.downloads[p.Path] = p
+	a.mutex.Unlock()
+
+	if a.next != nil {
+		a.next.Report(ctx, p)
+	}
+}
+
+// Summary returns the combined progress of all of the downloads that
+// have been reported. The rate is the average since the aggregator
+// was created.
+func (a *ProgressAggregator) Summary() ProgressSummary {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	out := ProgressSummary{Downloads: len(a.downloads)}
+	for _, p := range a.downloads {
+		out.Received += p.Received
+		if p.Done {
+			out.Completed++
+		}
+		if p.Total < 0 || out.Total < 0 {
+			out.Total = -1
+		} else {
+			out.Total += p.Total
+		}
+	}
+
+	if elapsed := time.Since(a.started); elapsed > 0 {
+		out.Rate = float64(out.Received) / elapsed.Seconds()
+	}
+	if out.Rate > 0 && out.Total > out.Received {
+		out.ETA = time.Duration(float64(out.Total-out.Received) / out.Rate * float64(time.Second))
+	}
+
+	return out
+}
+
+// progressReader reports the progress of reading a download's body.
+type progressReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	reporter ProgressReporter
+	url      string
+	path     string
+	total    int64
+	received int64
+	started  time.Time
+	reported time.Time
+}
+
+func newProgressReader(ctx context.Context, reader io.Reader, url, path string, total int64) *progressReader {
+	now := time.Now()
+	return &progressReader{
+		ctx:      ctx,
+		reader:   reader,
+		reporter: GetProgressReporter(ctx),
+		url:      url,
+		path:     path,
+		total:    total,
+		started:  now,
+		reported: now,
+	}
+}
+
+func (r *progressReader) Read(buf []byte) (int, error) {
+	n, err := r.reader.Read(buf)
+	r.received += int64(n)
+
+	if now := time.Now(); now.Sub(r.reported) >= progressInterval {
+		r.reported = now
+		r.reporter.Report(r.ctx, newDownloadProgress(r.url, r.path, r.received, r.total, now.Sub(r.started)))
+	}
+
+	return n, err
+}
+
+func (r *progressReader) done() {
+	p := newDownloadProgress(r.url, r.path, r.received, r.total, time.Since(r.started))
+	p.Done = true
+	r.reporter.Report(r.ctx, p)
+}
@@ -0,0 +1,101 @@
+package bond
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDownloadProgress(t *testing.T) {
+	p := newDownloadProgress("url", "path", 100, 400, 2*time.Second)
+	assert.Equal(t, 50.0, p.Rate)
+	assert.Equal(t, 6*time.Second, p.ETA)
+
+	p = newDownloadProgress("url", "path", 100, -1, 2*time.Second)
+	assert.Zero(t, p.ETA)
+
+	p = newDownloadProgress("url", "path", 0, 400, 0)
+	assert.Zero(t, p.Rate)
+	assert.Zero(t, p.ETA)
+}
+
+func TestDownloadFileReportsProgress(t *testing.T) {
+	data := make([]byte, 1<<20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	var (
+		mu      sync.Mutex
+		reports []DownloadProgress
+	)
+	ctx := WithProgressReporter(context.Background(), ProgressReporterFunc(func(_ context.Context, p DownloadProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, p)
+	}))
+
+	fn := filepath.Join(t.TempDir(), "archive.tgz")
+	require.NoError(t, DownloadFile(ctx, srv.URL+"/archive.tgz", fn))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, reports)
+	last := reports[len(reports)-1]
+	assert.True(t, last.Done)
+	assert.Equal(t, srv.URL+"/archive.tgz", last.URL)
+	assert.Equal(t, fn, last.Path)
+	assert.EqualValues(t, len(data), last.Received)
+	assert.EqualValues(t, len(data), last.Total)
+}
+
+func TestProgressAggregator(t *testing.T) {
+	ctx := context.Background()
+	var forwarded int
+	agg := NewProgressAggregator(ProgressReporterFunc(func(context.Context, DownloadProgress) { forwarded++ }))
+
+	agg.Report(ctx, DownloadProgress{Path: "a", Received: 10, Total: 100})
+	agg.Report(ctx, DownloadProgress{Path: "b", Received: 50, Total: 50, Done: true})
+	agg.Report(ctx, DownloadProgress{Path: "a", Received: 20, Total: 100})
+
+	summary := agg.Summary()
+	assert.Equal(t, 3, forwarded)
+	assert.Equal(t, 2, summary.Downloads)
+	assert.Equal(t, 1, summary.Completed)
+	assert.EqualValues(t, 70, summary.Received)
+	assert.EqualValues(t, 150, summary.Total)
+
+	agg.Report(ctx, DownloadProgress{Path: "c", Received: 10, Total: -1})
+	assert.EqualValues(t, -1, agg.Summary().Total)
+	assert.Zero(t, agg.Summary().ETA)
+
+	// the next reporter is optional.
+	NewProgressAggregator(nil).Report(ctx, DownloadProgress{Path: "a"})
+}
+
+func TestLoggingProgressReporter(t *testing.T) {
+	ctx := context.Background()
+	reporter := NewLoggingProgressReporter(time.Minute).(*loggingProgressReporter)
+
+	reporter.Report(ctx, DownloadProgress{Path: "a", Elapsed: time.Second})
+	reporter.Report(ctx, DownloadProgress{Path: "a", Elapsed: 2 * time.Second})
+	assert.Equal(t, time.Second, reporter.logged["a"], "reports within the interval are not logged")
+
+	reporter.Report(ctx, DownloadProgress{Path: "a", Elapsed: 2 * time.Minute})
+	assert.Equal(t, 2*time.Minute, reporter.logged["a"])
+
+	reporter.Report(ctx, DownloadProgress{Path: "a", Elapsed: 3 * time.Minute, Done: true})
+	assert.Empty(t, reporter.logged)
+
+	assert.Equal(t, defaultProgressReporter, GetProgressReporter(ctx))
+}
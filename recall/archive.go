@@ -0,0 +1,492 @@
+package recall
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// archiveExtensions are the file name suffixes of supported archive
+// formats. Formats are detected by content rather than by name, but
+// the suffixes determine the name of the directory that an archive
+// is extracted into.
+var archiveExtensions = []string{
+	".tar.gz", ".tar.xz", ".tar.zst", ".tar.bz2",
+	".tgz", ".txz", ".tzst", ".tbz2",
+	".tar", ".zip",
+}
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06")
+)
+
+// Archives from mirrors are not trusted, so extraction stops when an
+// archive contains entries that would be written outside of the
+// destination or when it exceeds these limits.
+const (
+	maxExtractedSize  = 32 << 30
+	maxExtractedFiles = 100000
+)
+
+var (
+	// ErrUnsafeArchivePath is returned when an archive contains an
+	// absolute path or a path that refers to a parent directory.
+	ErrUnsafeArchivePath = errors.New("archive entry is outside of the destination")
+	// ErrUnsafeArchiveLink is returned when an archive contains a link
+	// that points outside of the destination, or an entry that would
+	// be written through a link.
+	ErrUnsafeArchiveLink = errors.New("archive link is outside of the destination")
+	// ErrArchiveTooLarge is returned when the extracted contents of an
+	// archive exceed the maximum size.
+	ErrArchiveTooLarge = errors.New("archive exceeds the maximum extracted size")
+	// ErrArchiveTooManyFiles is returned when an archive contains more
+	// than the maximum number of files.
+	ErrArchiveTooManyFiles = errors.New("archive exceeds the maximum number of files")
+)
+
+// archiveBaseName returns the path of an archive without its archive
+// extension, which is where the archive is extracted.
+func archiveBaseName(fn string) string {
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(fn, ext) {
+			return strings.TrimSuffix(fn, ext)
+		}
+	}
+
+	return strings.TrimSuffix(fn, filepath.Ext(fn))
+}
+
+// extractArchiveRoot extracts a zip file or a (possibly compressed)
+// tarball into a directory next to the archive, named after the
+// archive, and returns the original name of the archive's root
+// directory, or an empty string if the archive doesn't have a single
+// root directory. The archive format is detected from the file's
+// contents.
+func extractArchiveRoot(fn string) (string, error) {
+	if archiveBaseName(fn) == fn {
+		return "", errors.Errorf("cannot determine directory name for archive '%s' without an extension", fn)
+	}
+
+	dir := filepath.Dir(fn)
+	baseName := filepath.Base(archiveBaseName(fn))
+
+	// archives are extracted into a staging directory first, because
+	// the root directory in an archive doesn't necessarily match the
+	// name of the archive, and debug symbol archives often share a
+	// root directory name with their build.
+	staging, err := ioutil.TempDir(dir, ".extract-"+baseName)
+	if err != nil {
+		return "", errors.Wrap(err, "creating staging directory")
+	}
+	defer os.RemoveAll(staging)
+
+	isZip, err := isZipArchive(fn)
+	if err != nil {
+		return "", errors.Wrapf(err, "detecting format of archive '%s'", fn)
+	}
+
+	if isZip {
+		err = extractZip(fn, staging)
+	} else {
+		err = extractTarball(fn, staging)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "extracting archive '%s'", fn)
+	}
+
+	root, err := promoteExtractedArchive(staging, filepath.Join(dir, baseName))
+	if err != nil {
+		return "", errors.Wrapf(err, "moving extracted archive '%s' into place", fn)
+	}
+
+	grip.Debug(context.Background(), message.Fields{
+		"file": fn,
+		"op":   "extracted archive",
+	})
+
+	return root, nil
+}
+
+func isZipArchive(fn string) (bool, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(f, magic); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+
+	return bytes.Equal(magic, zipMagic) || bytes.Equal(magic, emptyZipMagic), nil
+}
+
+func extractTarball(fn, dest string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return errors.Wrap(err, "opening archive")
+	}
+	defer f.Close()
+
+	r, err := newDecompressingReader(bufio.NewReader(f))
+	if err != nil {
+		return errors.Wrap(err, "decompressing archive")
+	}
+	defer r.Close()
+
+	e := newArchiveExtractor(dest)
+	tr := tar.NewReader(r)
+	for count := 0; ; count++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			if count == 0 {
+				return errors.New("archive is empty")
+			}
+			return nil
+		}
+		if err != nil {
+			if count == 0 {
+				return errors.Wrap(err, "file is in unsupported archive format")
+			}
+			return errors.Wrap(err, "reading archive contents")
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = e.mkdir(header.Name)
+		case tar.TypeReg:
+			err = e.writeFile(tr, header.Name, header.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = e.symlink(header.Name, header.Linkname)
+		case tar.TypeLink:
+			err = e.hardlink(header.Name, header.Linkname)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(fn, dest string) error {
+	r, err := zip.OpenReader(fn)
+	if err != nil {
+		return errors.Wrap(err, "reading archive")
+	}
+	defer r.Close()
+
+	if len(r.File) == 0 {
+		return errors.New("archive is empty")
+	}
+
+	e := newArchiveExtractor(dest)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			if err := e.mkdir(f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := func() error {
+			rc, err := f.Open()
+			if err != nil {
+				return errors.Wrapf(err, "opening '%s' in archive", f.Name)
+			}
+			defer rc.Close()
+
+			return e.writeFile(rc, f.Name, f.Mode())
+		}(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archiveExtractor writes the entries of an archive into a
+// destination directory, rejecting entries that would be written
+// outside of the destination and enforcing the extraction limits.
+type archiveExtractor struct {
+	dest     string
+	maxSize  int64
+	maxFiles int
+	size     int64
+	files    int
+}
+
+func newArchiveExtractor(dest string) *archiveExtractor {
+	return &archiveExtractor{
+		dest:     filepath.Clean(dest),
+		maxSize:  maxExtractedSize,
+		maxFiles: maxExtractedFiles,
+	}
+}
+
+// resolve returns the path in the destination for an archive entry.
+// Absolute paths, entries that contain "..", and entries that would be
+// written through a symbolic link are rejected.
+func (e *archiveExtractor) resolve(name string) (string, error) {
+	slashed := filepath.ToSlash(name)
+	if strings.HasPrefix(slashed, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", errors.Wrapf(ErrUnsafeArchivePath, "entry '%s' is absolute", name)
+	}
+
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return "", errors.Wrapf(ErrUnsafeArchivePath, "entry '%s' refers to a parent directory", name)
+		}
+	}
+
+	target := filepath.Join(e.dest, filepath.FromSlash(slashed))
+	rel, err := filepath.Rel(e.dest, target)
+	if err != nil || !isLocalPath(rel) {
+		return "", errors.Wrapf(ErrUnsafeArchivePath, "entry '%s' is outside of the destination", name)
+	}
+
+	// an earlier entry may have created a symbolic link to a directory,
+	// and writing through it could escape the destination.
+	parent := e.dest
+	for _, part := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		parent = filepath.Join(parent, part)
+		if info, err := os.Lstat(parent); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", errors.Wrapf(ErrUnsafeArchiveLink, "entry '%s' is inside of a symbolic link", name)
+		}
+	}
+
+	return target, nil
+}
+
+func (e *archiveExtractor) addEntry(name string) error {
+	e.files++
+	if e.files > e.maxFiles {
+		return errors.Wrapf(ErrArchiveTooManyFiles, "more than %d entries", e.maxFiles)
+	}
+	return nil
+}
+
+func (e *archiveExtractor) mkdir(name string) error {
+	target, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrapf(os.MkdirAll(target, 0755), "creating directory '%s'", target)
+}
+
+func (e *archiveExtractor) writeFile(r io.Reader, name string, mode os.FileMode) error {
+	target, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+	if err := e.addEntry(name); err != nil {
+		return err
+	}
+	if err := e.prepare(target); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return errors.Wrapf(err, "creating file '%s'", target)
+	}
+
+	// copy one byte more than the remaining allowance, to detect
+	// archives that exceed it regardless of their headers.
+	n, err := io.Copy(out, io.LimitReader(r, e.maxSize-e.size+1))
+	e.size += n
+	if err != nil {
+		_ = out.Close()
+		return errors.Wrapf(err, "writing file '%s'", target)
+	}
+	if e.size > e.maxSize {
+		_ = out.Close()
+		return errors.Wrapf(ErrArchiveTooLarge, "more than %d bytes", e.maxSize)
+	}
+
+	return errors.Wrapf(out.Close(), "closing file '%s'", target)
+}
+
+func (e *archiveExtractor) symlink(name, linkname string) error {
+	target, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+
+	if filepath.IsAbs(linkname) || strings.HasPrefix(linkname, "/") {
+		return errors.Wrapf(ErrUnsafeArchiveLink, "link '%s' points to absolute path '%s'", name, linkname)
+	}
+
+	rel, err := filepath.Rel(e.dest, filepath.Join(filepath.Dir(target), filepath.FromSlash(linkname)))
+	if err != nil || !isLocalPath(rel) {
+		return errors.Wrapf(ErrUnsafeArchiveLink, "link '%s' points to '%s' outside of the destination", name, linkname)
+	}
+
+	if err := e.addEntry(name); err != nil {
+		return err
+	}
+	if err := e.prepare(target); err != nil {
+		return err
+	}
+
+	if !e.linkInDestination(target, linkname) {
+		return errors.Wrapf(ErrUnsafeArchiveLink, "link '%s' resolves to '%s' outside of the destination", name, linkname)
+	}
+
+	return errors.Wrapf(os.Symlink(linkname, target), "creating link '%s'", target)
+}
+
+// maxLinkResolutions limits the links followed when resolving the
+// target of a link.
+const maxLinkResolutions = 40
+
+// linkInDestination resolves the target of a link that will be
+// created at target, following the links that are already extracted,
+// and returns false if the link would point outside of the
+// destination. Entries that don't exist yet may later be extracted as
+// links, so parent references that follow them are rejected.
+func (e *archiveExtractor) linkInDestination(target, linkname string) bool {
+	dir, err := filepath.Rel(e.dest, filepath.Dir(target))
+	if err != nil {
+		return false
+	}
+
+	parts := append(splitLinkPath(dir), splitLinkPath(linkname)...)
+	var current []string
+	missing := false
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if missing || len(current) == 0 {
+				return false
+			}
+			current = current[:len(current)-1]
+			continue
+		}
+
+		current = append(current, part)
+		if missing {
+			continue
+		}
+
+		path := filepath.Join(append([]string{e.dest}, current...)...)
+		info, err := os.Lstat(path)
+		if err != nil {
+			missing = true
+			continue
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		links++
+		dest, err := os.Readlink(path)
+		if err != nil || links > maxLinkResolutions || filepath.IsAbs(dest) {
+			return false
+		}
+		current = current[:len(current)-1]
+		parts = append(splitLinkPath(dest), parts...)
+	}
+
+	return true
+}
+
+func splitLinkPath(path string) []string {
+	return strings.Split(filepath.ToSlash(path), "/")
+}
+
+func (e *archiveExtractor) hardlink(name, linkname string) error {
+	target, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+
+	source, err := e.resolve(linkname)
+	if err != nil {
+		return errors.Wrapf(ErrUnsafeArchiveLink, "link '%s' points to '%s' outside of the destination", name, linkname)
+	}
+
+	if err := e.addEntry(name); err != nil {
+		return err
+	}
+	if err := e.prepare(target); err != nil {
+		return err
+	}
+
+	return errors.Wrapf(os.Link(source, target), "creating link '%s'", target)
+}
+
+// prepare creates the parent directory of a file, and removes any
+// existing link at the file's path so that it isn't written through.
+func (e *archiveExtractor) prepare(target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return errors.Wrapf(err, "creating directory for '%s'", target)
+	}
+
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return errors.Wrapf(err, "removing existing link '%s'", target)
+		}
+	}
+
+	return nil
+}
+
+func isLocalPath(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// promoteExtractedArchive moves the contents of an archive extracted
+// into the staging directory to the destination. Archives with a
+// single root directory (e.g. mongodb-<platform>-<version>/bin/mongod)
+// have that directory renamed to the destination, and its name is
+// returned, otherwise the staging directory becomes the destination.
+func promoteExtractedArchive(staging, dest string) (string, error) {
+	contents, err := ioutil.ReadDir(staging)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading contents of '%s'", staging)
+	}
+
+	if len(contents) == 0 {
+		return "", errors.New("archive is empty")
+	}
+
+	if err := os.RemoveAll(dest); err != nil {
+		return "", errors.Wrapf(err, "removing existing directory '%s'", dest)
+	}
+
+	var name string
+	root := staging
+	if len(contents) == 1 && contents[0].IsDir() {
+		name = contents[0].Name()
+		root = filepath.Join(staging, name)
+	} else if err := os.Chmod(staging, 0755); err != nil {
+		return "", errors.Wrapf(err, "setting permissions on '%s'", staging)
+	}
+
+	if err := os.Rename(root, dest); err != nil {
+		return "", errors.Wrapf(err, "renaming directory '%s' to '%s'", root, dest)
+	}
+
+	return name, nil
+}
@@ -0,0 +1,303 @@
+package recall
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestZip(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestArchiveBaseName(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	for fn, base := range map[string]string{
+		"mongodb-linux-x86_64-4.4.1.tgz":                   "mongodb-linux-x86_64-4.4.1",
+		"mongodb-linux-x86_64-4.4.1.tar.gz":                "mongodb-linux-x86_64-4.4.1",
+		"mongodb-linux-x86_64-4.4.1.tar.xz":                "mongodb-linux-x86_64-4.4.1",
+		"mongodb-linux-x86_64-4.4.1.tar.zst":               "mongodb-linux-x86_64-4.4.1",
+		"mongodb-linux-x86_64-4.4.1.tar":                   "mongodb-linux-x86_64-4.4.1",
+		"mongodb-windows-x86_64-4.4.1.zip":                 "mongodb-windows-x86_64-4.4.1",
+		"/data/mongodb-linux-x86_64-v4.4-latest.tgz":       "/data/mongodb-linux-x86_64-v4.4-latest",
+		"mongodb-linux-x86_64-enterprise-rhel80-4.4.1.txz": "mongodb-linux-x86_64-enterprise-rhel80-4.4.1",
+	} {
+		assert.Equal(base, archiveBaseName(fn), fn)
+	}
+}
+
+func TestExtractArchiveDetectsFormatsByContent(t *testing.T) {
+	t.Parallel()
+
+	files := map[string]string{
+		"mongodb-linux-x86_64-4.4.1/bin/mongod": "mongod",
+		"mongodb-linux-x86_64-4.4.1/bin/mongos": "mongos",
+	}
+
+	for name, archive := range map[string]func(t *testing.T) []byte{
+		"foo.tgz":     func(t *testing.T) []byte { return gzipBytes(t, makeTestTar(t, files)) },
+		"foo.tar.gz":  func(t *testing.T) []byte { return gzipBytes(t, makeTestTar(t, files)) },
+		"foo.tar.xz":  func(t *testing.T) []byte { return xzBytes(t, makeTestTar(t, files)) },
+		"foo.tar.zst": func(t *testing.T) []byte { return zstdBytes(t, makeTestTar(t, files)) },
+		"foo.tar":     func(t *testing.T) []byte { return makeTestTar(t, files) },
+		"foo.zip":     func(t *testing.T) []byte { return makeTestZip(t, files) },
+		// mirrors don't always use accurate extensions
+		"foo.zst":      func(t *testing.T) []byte { return makeTestZip(t, files) },
+		"foo.download": func(t *testing.T) []byte { return xzBytes(t, makeTestTar(t, files)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			fn := filepath.Join(dir, name)
+			require.NoError(t, ioutil.WriteFile(fn, archive(t), 0644))
+
+			_, err := extractArchiveRoot(fn)
+			require.NoError(t, err)
+
+			for _, bin := range []string{"mongod", "mongos"} {
+				data, err := ioutil.ReadFile(filepath.Join(archiveBaseName(fn), "bin", bin))
+				assert.NoError(t, err)
+				assert.Equal(t, bin, string(data))
+			}
+		})
+	}
+}
+
+func TestExtractArchiveRejectsInvalidArchives(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	for name, content := range map[string][]byte{
+		"default.json": []byte(`{"versions": []}`),
+		"no-extension": gzipBytes(t, makeTestTar(t, map[string]string{"foo/bin/mongod": "mongod"})),
+		"empty.tgz":    {},
+		"empty.zip":    makeTestZip(t, nil),
+		"garbage.tgz":  gzipBytes(t, []byte("not a tarball")),
+	} {
+		fn := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(fn, content, 0644))
+		_, err := extractArchiveRoot(fn)
+		assert.Error(t, err, name)
+	}
+
+	contents, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, contents, 5, "failed extractions should not leave directories behind")
+}
+
+func TestExtractArchiveKeepsBuildAndDebugSymbolsSeparate(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	build := filepath.Join(dir, "mongodb-linux-x86_64-3.2.11.tgz")
+	require.NoError(t, ioutil.WriteFile(build, gzipBytes(t, makeTestTar(t, map[string]string{
+		"mongodb-linux-x86_64-3.2.11/bin/mongod": "mongod",
+		"mongodb-linux-x86_64-3.2.11/bin/mongos": "mongos",
+	})), 0644))
+	// debug symbol archives use the same root directory as the build.
+	symbols := filepath.Join(dir, "mongodb-linux-x86_64-debugsymbols-3.2.11.tgz")
+	require.NoError(t, ioutil.WriteFile(symbols, gzipBytes(t, makeTestTar(t, map[string]string{
+		"mongodb-linux-x86_64-3.2.11/mongod.debug": "symbols",
+		"mongodb-linux-x86_64-3.2.11/mongos.debug": "symbols",
+	})), 0644))
+
+	_, err := extractArchiveRoot(build)
+	require.NoError(t, err)
+	_, err = extractArchiveRoot(symbols)
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, "mongodb-linux-x86_64-3.2.11", "bin", "mongod"))
+	assert.NoError(err)
+	_, err = os.Stat(filepath.Join(dir, "mongodb-linux-x86_64-3.2.11", "mongod.debug"))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "mongodb-linux-x86_64-debugsymbols-3.2.11", "mongod.debug"))
+	assert.NoError(err)
+
+	contents, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(contents, 4, "staging directories should be removed")
+}
+
+func makeTestTarHeaders(t *testing.T, headers ...*tar.Header) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg && header.Size == 0 {
+			header.Size = int64(len(header.Name))
+		}
+		if header.Mode == 0 {
+			header.Mode = 0755
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		if header.Typeflag == tar.TypeReg {
+			_, err := tw.Write(bytes.Repeat([]byte("x"), int(header.Size)))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestExtractArchiveRejectsUnsafeEntries(t *testing.T) {
+	t.Parallel()
+
+	for name, test := range map[string]struct {
+		archive []byte
+		err     error
+	}{
+		"ParentDirectory": {
+			archive: makeTestTarHeaders(t, &tar.Header{Name: "foo/../../escaped", Typeflag: tar.TypeReg}),
+			err:     ErrUnsafeArchivePath,
+		},
+		"AbsolutePath": {
+			archive: makeTestTarHeaders(t, &tar.Header{Name: "/tmp/escaped", Typeflag: tar.TypeReg}),
+			err:     ErrUnsafeArchivePath,
+		},
+		"ZipParentDirectory": {
+			archive: makeTestZip(t, map[string]string{"../escaped": "escaped"}),
+			err:     ErrUnsafeArchivePath,
+		},
+		"AbsoluteSymlink": {
+			archive: makeTestTarHeaders(t, &tar.Header{Name: "foo/bin", Linkname: "/usr/bin", Typeflag: tar.TypeSymlink}),
+			err:     ErrUnsafeArchiveLink,
+		},
+		"EscapingSymlink": {
+			archive: makeTestTarHeaders(t, &tar.Header{Name: "foo/bin", Linkname: "../../..", Typeflag: tar.TypeSymlink}),
+			err:     ErrUnsafeArchiveLink,
+		},
+		"EscapingHardlink": {
+			archive: makeTestTarHeaders(t, &tar.Header{Name: "foo/passwd", Linkname: "../etc/passwd", Typeflag: tar.TypeLink}),
+			err:     ErrUnsafeArchiveLink,
+		},
+		"WriteThroughSymlink": {
+			// each link is inside of the destination on its own, but
+			// together they point to the destination's parent.
+			archive: makeTestTarHeaders(t,
+				&tar.Header{Name: "foo/sub/up", Linkname: "..", Typeflag: tar.TypeSymlink},
+				&tar.Header{Name: "foo/sub/up/escape", Linkname: "../..", Typeflag: tar.TypeSymlink},
+			),
+			err: ErrUnsafeArchiveLink,
+		},
+		"SymlinkThroughSymlink": {
+			// the second link is inside of the destination lexically,
+			// but resolves through the first link to its parent.
+			archive: makeTestTarHeaders(t,
+				&tar.Header{Name: "foo/y", Linkname: ".", Typeflag: tar.TypeSymlink},
+				&tar.Header{Name: "foo/x", Linkname: "y/../y/../y/..", Typeflag: tar.TypeSymlink},
+			),
+			err: ErrUnsafeArchiveLink,
+		},
+		"SymlinkThroughLaterSymlink": {
+			// the first link could be redirected by the second.
+			archive: makeTestTarHeaders(t,
+				&tar.Header{Name: "foo/x", Linkname: "y/../..", Typeflag: tar.TypeSymlink},
+				&tar.Header{Name: "foo/y", Linkname: ".", Typeflag: tar.TypeSymlink},
+			),
+			err: ErrUnsafeArchiveLink,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			fn := filepath.Join(dir, "archives", "foo.tar")
+			require.NoError(t, os.MkdirAll(filepath.Dir(fn), 0755))
+			require.NoError(t, ioutil.WriteFile(fn, test.archive, 0644))
+
+			_, err := extractArchiveRoot(fn)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, test.err), err.Error())
+
+			_, err = os.Stat(filepath.Join(dir, "escaped"))
+			assert.True(t, os.IsNotExist(err))
+			contents, err := ioutil.ReadDir(filepath.Dir(fn))
+			require.NoError(t, err)
+			assert.Len(t, contents, 1)
+		})
+	}
+}
+
+func TestExtractArchiveAllowsLinksInsideDestination(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	fn := filepath.Join(dir, "foo.tgz")
+	require.NoError(t, ioutil.WriteFile(fn, gzipBytes(t, makeTestTarHeaders(t,
+		&tar.Header{Name: "./", Typeflag: tar.TypeDir},
+		&tar.Header{Name: "./foo/bin/mongod", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "./foo/bin/mongod-link", Linkname: "mongod", Typeflag: tar.TypeSymlink},
+		&tar.Header{Name: "./foo/lib/mongod", Linkname: "../bin/mongod", Typeflag: tar.TypeSymlink},
+		&tar.Header{Name: "./foo/bin/mongod-hardlink", Linkname: "foo/bin/mongod", Typeflag: tar.TypeLink},
+	)), 0644))
+
+	_, err := extractArchiveRoot(fn)
+	require.NoError(t, err)
+
+	for _, name := range []string{"bin/mongod-link", "lib/mongod", "bin/mongod-hardlink"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "foo", name))
+		assert.NoError(err, name)
+		assert.Len(data, len("./foo/bin/mongod"), name)
+	}
+}
+
+func TestArchiveExtractorLimits(t *testing.T) {
+	t.Parallel()
+
+	archive := makeTestTarHeaders(t,
+		&tar.Header{Name: "foo/bin/mongod", Typeflag: tar.TypeReg, Size: 64},
+		&tar.Header{Name: "foo/bin/mongos", Typeflag: tar.TypeReg, Size: 64},
+		&tar.Header{Name: "foo/bin/mongo", Typeflag: tar.TypeReg, Size: 64},
+	)
+
+	for name, test := range map[string]struct {
+		maxSize  int64
+		maxFiles int
+		err      error
+	}{
+		"WithinLimits": {maxSize: 192, maxFiles: 3},
+		"TooLarge":     {maxSize: 191, maxFiles: 3, err: ErrArchiveTooLarge},
+		"TooManyFiles": {maxSize: 192, maxFiles: 2, err: ErrArchiveTooManyFiles},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := newArchiveExtractor(t.TempDir())
+			e.maxSize = test.maxSize
+			e.maxFiles = test.maxFiles
+
+			tr := tar.NewReader(bytes.NewReader(archive))
+			var err error
+			for {
+				header, nextErr := tr.Next()
+				if nextErr != nil {
+					break
+				}
+				if err = e.writeFile(tr, header.Name, header.FileInfo().Mode()); err != nil {
+					break
+				}
+			}
+
+			if test.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, test.err), "%v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package recall
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+)
+
+// newDecompressingReader inspects the leading bytes of a stream and
+// returns a reader that decompresses the stream if it is gzip, xz,
+// zstd or bzip2 compressed. Uncompressed streams are returned as-is.
+// Callers must close the returned reader.
+func newDecompressingReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "reading compression header")
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading gzip stream")
+		}
+		return gzr, nil
+	case bytes.HasPrefix(magic, xzMagic):
+		xzr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading xz stream")
+		}
+		return ioutil.NopCloser(xzr), nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading zstd stream")
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return ioutil.NopCloser(bzip2.NewReader(br)), nil
+	default:
+		return ioutil.NopCloser(br), nil
+	}
+}
@@ -0,0 +1,191 @@
+package recall
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/evergreen-ci/bond"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes the builds that should be in a local cache. Config
+// files are YAML or JSON, for example:
+//
+//	destination: /data/mongodb
+//	releases: ["4.4-current", "6.0.5"]
+//	matrix:
+//	  targets: [rhel80, ubuntu2204]
+//	  arches: [x86_64]
+//	  editions: [enterprise, targeted]
+//	retention:
+//	  prune: true
+//	  min_age: 24h
+type Config struct {
+	// Destination is the directory of the cache.
+	Destination string              `bson:"destination" json:"destination" yaml:"destination"`
+	Releases    []string            `bson:"releases" json:"releases" yaml:"releases"`
+	Builds      []bond.BuildOptions `bson:"builds" json:"builds" yaml:"builds"`
+	Matrix      bond.BuildMatrix    `bson:"matrix" json:"matrix" yaml:"matrix"`
+	Limits      DownloadLimits      `bson:"limits" json:"limits" yaml:"limits"`
+	Retention   RetentionPolicy     `bson:"retention" json:"retention" yaml:"retention"`
+}
+
+// RetentionPolicy describes which of the builds in a cache that a
+// config does not list are removed.
+type RetentionPolicy struct {
+	// Prune removes the builds that the config does not list.
+	Prune bool `bson:"prune" json:"prune" yaml:"prune"`
+	// MinAge keeps unlisted builds that were modified more recently.
+	MinAge time.Duration `bson:"min_age" json:"min_age" yaml:"min_age"`
+	// Keep lists patterns (see filepath.Match) of the names of
+	// builds that are never removed.
+	Keep []string `bson:"keep" json:"keep" yaml:"keep"`
+}
+
+// LoadConfig reads a YAML or JSON config file.
+func LoadConfig(fn string) (*Config, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading config '%s'", fn)
+	}
+
+	conf, err := ParseConfig(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing config '%s'", fn)
+	}
+
+	return conf, nil
+}
+
+// ParseConfig parses a YAML or JSON config.
+func ParseConfig(data []byte) (*Config, error) {
+	conf := &Config{}
+	if err := yaml.Unmarshal(data, conf); err != nil {
+		return nil, errors.Wrap(err, "decoding config")
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+
+	return conf, nil
+}
+
+// Validate checks that the config specifies a destination, releases
+// and builds.
+func (c *Config) Validate() error {
+	catcher := grip.NewCatcher()
+	catcher.NewWhen(c.Destination == "", "must specify a destination")
+	catcher.NewWhen(len(c.Releases) == 0, "must specify at least one release")
+	catcher.NewWhen(c.Retention.MinAge < 0, "retention minimum age must not be negative")
+	for _, pattern := range c.Retention.Keep {
+		_, err := filepath.Match(pattern, "")
+		catcher.Wrapf(err, "invalid retention pattern '%s'", pattern)
+	}
+
+	opts := c.fetchOptions()
+	catcher.Add(opts.Validate())
+
+	return bond.ResolveInvalidOptions(catcher)
+}
+
+func (c *Config) fetchOptions() FetchOptions {
+	return FetchOptions{
+		Releases: c.Releases,
+		Path:     c.Destination,
+		Builds:   c.Builds,
+		Matrix:   c.Matrix,
+		Limits:   c.Limits,
+	}
+}
+
+// ReconcileReport describes the changes that Reconcile made.
+type ReconcileReport struct {
+	Builds []FetchResult `bson:"builds" json:"builds" yaml:"builds"`
+	// Pruned lists the paths of the builds and archives that were
+	// removed.
+	Pruned []string `bson:"pruned,omitempty" json:"pruned,omitempty" yaml:"pruned,omitempty"`
+}
+
+// Reconcile fetches the builds in the config that are missing from
+// the cache, and when the retention policy prunes builds, removes the
+// builds and archives that the config does not list. Nothing is
+// removed if any build could not be fetched.
+func Reconcile(ctx context.Context, conf *Config) (*ReconcileReport, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+
+	results, err := Fetch(ctx, conf.fetchOptions())
+	report := &ReconcileReport{Builds: results}
+	if err != nil {
+		return report, errors.Wrap(err, "fetching builds")
+	}
+
+	if !conf.Retention.Prune {
+		return report, nil
+	}
+
+	report.Pruned, err = prune(ctx, conf, results)
+	return report, errors.Wrap(err, "pruning builds")
+}
+
+func prune(ctx context.Context, conf *Config, results []FetchResult) ([]string, error) {
+	listed := map[string]bool{}
+	for _, res := range results {
+		listed[filepath.Base(res.Archive)] = true
+		if res.Directory != "" {
+			listed[filepath.Base(res.Directory)] = true
+		}
+	}
+
+	contents, err := ioutil.ReadDir(conf.Destination)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading directory '%s'", conf.Destination)
+	}
+
+	var pruned []string
+	catcher := grip.NewCatcher()
+	for _, info := range contents {
+		name := info.Name()
+		if !strings.HasPrefix(name, "mongodb-") || listed[name] {
+			continue
+		}
+		if time.Since(info.ModTime()) < conf.Retention.MinAge || conf.Retention.keeps(name) {
+			continue
+		}
+
+		fn := filepath.Join(conf.Destination, name)
+		if err := os.RemoveAll(fn); err != nil {
+			catcher.Wrapf(err, "removing '%s'", fn)
+			continue
+		}
+		// archives that were refreshed have their validators in a
+		// hidden file next to them.
+		catcher.Add(bond.RemoveDownloadValidators(fn))
+
+		grip.Info(ctx, message.Fields{
+			"message": "pruned unlisted build",
+			"path":    fn,
+		})
+		pruned = append(pruned, fn)
+	}
+
+	return pruned, bond.ResolveErrors(catcher)
+}
+
+func (p RetentionPolicy) keeps(name string) bool {
+	for _, pattern := range p.Keep {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package recall
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/bond"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Run("YAML", func(t *testing.T) {
+		conf, err := ParseConfig([]byte(`
+destination: /data/mongodb
+releases: ["4.4-current", "6.0.5"]
+builds:
+  - target: linux_x86_64
+    arch: x86_64
+    edition: base
+matrix:
+  targets: [rhel80]
+  arches: [x86_64]
+  editions: [enterprise]
+  debug: true
+limits:
+  workers: 2
+  bytes_per_second: 1048576
+retention:
+  prune: true
+  min_age: 24h
+  keep: ["mongodb-*-4.2.*"]
+`))
+		require.NoError(t, err)
+		assert.Equal(t, "/data/mongodb", conf.Destination)
+		assert.Equal(t, []string{"4.4-current", "6.0.5"}, conf.Releases)
+		assert.Equal(t, []bond.BuildOptions{{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}}, conf.Builds)
+		assert.Equal(t, []string{"rhel80"}, conf.Matrix.Targets)
+		assert.Equal(t, []bond.MongoDBEdition{bond.Enterprise}, conf.Matrix.Editions)
+		assert.True(t, conf.Matrix.Debug)
+		assert.Equal(t, 2, conf.Limits.Workers)
+		assert.EqualValues(t, 1048576, conf.Limits.BytesPerSecond)
+		assert.True(t, conf.Retention.Prune)
+		assert.Equal(t, 24*time.Hour, conf.Retention.MinAge)
+		assert.Equal(t, []string{"mongodb-*-4.2.*"}, conf.Retention.Keep)
+	})
+	t.Run("JSON", func(t *testing.T) {
+		conf, err := ParseConfig([]byte(`{
+  "destination": "/data/mongodb",
+  "releases": ["4.4.1"],
+  "builds": [{"target": "linux_x86_64", "arch": "x86_64", "edition": "base"}]
+}`))
+		require.NoError(t, err)
+		assert.Equal(t, "/data/mongodb", conf.Destination)
+		assert.Equal(t, []string{"4.4.1"}, conf.Releases)
+		assert.False(t, conf.Retention.Prune)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		for name, data := range map[string]string{
+			"Malformed":      "releases: [",
+			"NoDestination":  "releases: [4.4.1]\nbuilds: [{target: linux_x86_64, arch: x86_64, edition: base}]",
+			"NoReleases":     "destination: /tmp\nbuilds: [{target: linux_x86_64, arch: x86_64, edition: base}]",
+			"NoBuilds":       "destination: /tmp\nreleases: [4.4.1]",
+			"NegativeMinAge": "destination: /tmp\nreleases: [4.4.1]\nbuilds: [{target: linux_x86_64, arch: x86_64, edition: base}]\nretention: {min_age: -1h}",
+			"BadPattern":     "destination: /tmp\nreleases: [4.4.1]\nbuilds: [{target: linux_x86_64, arch: x86_64, edition: base}]\nretention: {keep: ['[']}",
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := ParseConfig([]byte(data))
+				assert.Error(t, err)
+			})
+		}
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "bond.yaml")
+	require.NoError(t, ioutil.WriteFile(fn, []byte("destination: /tmp\nreleases: [4.4.1]\nbuilds: [{target: linux_x86_64, arch: x86_64, edition: base}]\n"), 0644))
+
+	conf, err := LoadConfig(fn)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"4.4.1"}, conf.Releases)
+
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	base := bond.BuildOptions{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}
+
+	var requests int32
+	_, dir := newTestFetchServer(t, &requests)
+	conf := &Config{
+		Destination: dir,
+		Releases:    []string{"4.4.1", "4.4.0"},
+		Builds:      []bond.BuildOptions{base},
+	}
+
+	report, err := Reconcile(ctx, conf)
+	require.NoError(t, err)
+	require.Len(t, report.Builds, 2)
+	assert.Empty(t, report.Pruned)
+
+	old := filepath.Join(dir, "mongodb-linux-x86_64-4.4.0")
+	oldArchive := old + ".tgz"
+	kept := filepath.Join(dir, "mongodb-linux-x86_64-4.2.0")
+	require.NoError(t, os.Mkdir(kept, 0755))
+
+	t.Run("WithoutPruneKeepsUnlistedBuilds", func(t *testing.T) {
+		conf.Releases = []string{"4.4.1"}
+		report, err := Reconcile(ctx, conf)
+		require.NoError(t, err)
+		assert.Empty(t, report.Pruned)
+		assert.DirExists(t, old)
+	})
+	t.Run("FailedFetchDoesNotPrune", func(t *testing.T) {
+		conf.Releases = []string{"4.4.1", "9.9.9"}
+		conf.Retention = RetentionPolicy{Prune: true}
+		_, err := Reconcile(ctx, conf)
+		assert.Error(t, err)
+		assert.DirExists(t, old)
+		assert.FileExists(t, oldArchive)
+	})
+	t.Run("MinAgeKeepsRecentBuilds", func(t *testing.T) {
+		conf.Releases = []string{"4.4.1"}
+		conf.Retention = RetentionPolicy{Prune: true, MinAge: time.Hour}
+		report, err := Reconcile(ctx, conf)
+		require.NoError(t, err)
+		assert.Empty(t, report.Pruned)
+		assert.DirExists(t, old)
+	})
+	t.Run("PrunesUnlistedBuilds", func(t *testing.T) {
+		past := time.Now().Add(-2 * time.Hour)
+		for _, fn := range []string{old, oldArchive, kept} {
+			require.NoError(t, os.Chtimes(fn, past, past))
+		}
+		validators := filepath.Join(dir, "."+filepath.Base(oldArchive)+".http.json")
+		require.NoError(t, ioutil.WriteFile(validators, []byte(`{"url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.0.tgz"}`), 0644))
+
+		conf.Releases = []string{"4.4.1"}
+		conf.Retention = RetentionPolicy{Prune: true, MinAge: time.Hour, Keep: []string{"mongodb-*-4.2.*"}}
+		report, err := Reconcile(ctx, conf)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{old, oldArchive}, report.Pruned)
+		assert.NoDirExists(t, old)
+		assert.NoFileExists(t, oldArchive)
+		assert.NoFileExists(t, validators)
+		assert.DirExists(t, kept)
+		assert.DirExists(t, filepath.Join(dir, "mongodb-linux-x86_64-4.4.1"))
+		assert.FileExists(t, filepath.Join(dir, "mongodb-linux-x86_64-4.4.1.tgz"))
+		assert.FileExists(t, filepath.Join(dir, "full.json"))
+	})
+}
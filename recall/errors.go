@@ -0,0 +1,8 @@
+package recall
+
+import "github.com/evergreen-ci/bond"
+
+// ErrChecksumMismatch is returned when the checksum of an archive
+// does not match the expected checksum. It is the same error as
+// bond.ErrChecksumMismatch.
+var ErrChecksumMismatch = bond.ErrChecksumMismatch
@@ -0,0 +1,695 @@
+package recall
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/evergreen-ci/bond"
+	"github.com/mongodb/amboy"
+	"github.com/mongodb/amboy/queue"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultFeedTTL   = 4 * time.Hour
+	defaultQueueSize = 1048
+)
+
+// FetchOptions configures Fetch.
+type FetchOptions struct {
+	// Releases are the versions, series (e.g. "4.4") or nightly
+	// builds (e.g. "4.4-latest") to fetch.
+	Releases []string `bson:"releases" json:"releases" yaml:"releases"`
+	// Path is the directory that the feed and builds are
+	// downloaded into.
+	Path string `bson:"path" json:"path" yaml:"path"`
+	// Builds are the build variants to fetch for every release.
+	Builds []bond.BuildOptions `bson:"builds" json:"builds" yaml:"builds"`
+	// Matrix adds the cross product of its targets, arches and
+	// editions to the builds.
+	Matrix bond.BuildMatrix `bson:"matrix" json:"matrix" yaml:"matrix"`
+	// Limits configures the workers, bandwidth and connections
+	// that the downloads use.
+	Limits DownloadLimits `bson:"limits" json:"limits" yaml:"limits"`
+	// Force downloads the builds again, even if they're already
+	// downloaded.
+	Force bool `bson:"force" json:"force" yaml:"force"`
+	// FeedTTL is the age at which the cached feed is downloaded
+	// again. Defaults to 4 hours.
+	FeedTTL time.Duration `bson:"feed_ttl" json:"feed_ttl" yaml:"feed_ttl"`
+	// QueueSize is the capacity of the download queue. Defaults
+	// to 1048.
+	QueueSize int `bson:"queue_size" json:"queue_size" yaml:"queue_size"`
+	// SkipExtract only downloads the archives, without extracting
+	// them.
+	SkipExtract bool `bson:"skip_extract" json:"skip_extract" yaml:"skip_extract"`
+	// Packages fetches the distribution packages (deb or rpm) of
+	// each build rather than its archive, and extracts the binaries
+	// that they contain into the directory that the archive would
+	// be extracted into, so that the result can be used in a
+	// BuildCatalog. The feed does not publish checksums for
+	// packages, so unlike archives they are not verified against a
+	// sha256, but the git hash that the extracted binaries report,
+	// if any, must still match the feed's.
+	Packages bool `bson:"packages" json:"packages" yaml:"packages"`
+	// Manifest, if set, specifies the exact archives to fetch,
+	// instead of the releases and builds, and the checksums that
+	// the archives must match.
+	Manifest *Manifest `bson:"manifest,omitempty" json:"manifest,omitempty" yaml:"manifest,omitempty"`
+	// ManifestFile, if set, is the file that Fetch writes the
+	// manifest of a successful fetch to.
+	ManifestFile string `bson:"manifest_file" json:"manifest_file" yaml:"manifest_file"`
+	// Queue is the queue that runs the download jobs, which may be
+	// shared with other fetches or hosts. By default, Fetch uses a
+	// local queue with the workers and size in the options. Fetch
+	// starts the queue if it isn't started; its jobs run with the
+	// context that the queue was started with, so the download
+	// limits, the queue size and the progress reporter of Fetch's
+	// context do not apply to them, and are invalid with a queue.
+	Queue amboy.Queue `bson:"-" json:"-" yaml:"-"`
+}
+
+// Validate checks that the options are valid.
+func (o *FetchOptions) Validate() error {
+	catcher := grip.NewCatcher()
+	if o.Manifest != nil {
+		catcher.NewWhen(len(o.Releases) > 0 || len(o.Builds) > 0 || !o.Matrix.IsZero() || o.Packages,
+			"cannot specify releases, builds or packages with a manifest")
+		catcher.Wrap(o.Manifest.Validate(), "invalid manifest")
+		catcher.Add(o.validateQueue())
+		return bond.ResolveInvalidOptions(catcher)
+	}
+
+	catcher.NewWhen(len(o.Builds) == 0 && o.Matrix.IsZero(), "must specify at least one build")
+	if !o.Matrix.IsZero() {
+		catcher.Wrap(o.Matrix.Validate(), "invalid build matrix")
+	}
+	for _, opts := range o.Builds {
+		catcher.Wrapf(opts.Validate(), "invalid build options %s", opts)
+		catcher.ErrorfWhen(o.Packages && opts.Debug, "cannot fetch packages of debug build %s", opts)
+	}
+	catcher.NewWhen(o.Packages && o.Matrix.Debug, "cannot fetch packages of debug builds")
+	catcher.NewWhen(o.Packages && o.SkipExtract, "cannot skip extracting packages")
+	catcher.NewWhen(o.FeedTTL < 0, "feed TTL must not be negative")
+	catcher.Add(o.validateQueue())
+	return bond.ResolveInvalidOptions(catcher)
+}
+
+// validateQueue checks the options of the internal queue, which are
+// invalid with a caller's queue.
+func (o *FetchOptions) validateQueue() error {
+	catcher := grip.NewCatcher()
+	catcher.Wrap(o.Limits.Validate(), "invalid download limits")
+	catcher.NewWhen(o.QueueSize < 0, "queue size must not be negative")
+	if o.Queue != nil {
+		catcher.NewWhen(o.Limits != DownloadLimits{}, "cannot specify download limits with a queue")
+		catcher.NewWhen(o.QueueSize != 0, "cannot specify a queue size with a queue")
+	}
+	return bond.ResolveErrors(catcher)
+}
+
+// builds returns the explicit builds followed by the builds in the
+// matrix.
+func (o *FetchOptions) builds() []bond.BuildOptions {
+	return append(append([]bond.BuildOptions{}, o.Builds...), o.Matrix.Expand()...)
+}
+
+func (o *FetchOptions) feedTTL() time.Duration {
+	if o.FeedTTL == 0 {
+		return defaultFeedTTL
+	}
+	return o.FeedTTL
+}
+
+func (o *FetchOptions) queueSize() int {
+	if o.QueueSize == 0 {
+		return defaultQueueSize
+	}
+	return o.QueueSize
+}
+
+// FetchResult describes a build archive that Fetch resolved.
+type FetchResult struct {
+	// Release is the release that resolved to the archive, as it
+	// was requested.
+	Release string         `bson:"release" json:"release" yaml:"release"`
+	Build   bond.BuildInfo `bson:"build" json:"build" yaml:"build"`
+	URL     string         `bson:"url" json:"url" yaml:"url"`
+	// Sha256 is the expected checksum of the archive. When Fetch
+	// writes a manifest, archives that the feed has no checksum for
+	// are set to the checksum of the fetched archive.
+	Sha256 string `bson:"sha256,omitempty" json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	// GitHash is the expected git hash of the build. Extracted
+	// builds that report a different git hash are an error.
+	GitHash string `bson:"githash,omitempty" json:"githash,omitempty" yaml:"githash,omitempty"`
+	// Archive is the local path of the archive, or empty if the
+	// build is fetched from its packages.
+	Archive string `bson:"archive,omitempty" json:"archive,omitempty" yaml:"archive,omitempty"`
+	// Packages are the URLs of the distribution packages that the
+	// build is fetched from, instead of the archive at URL.
+	Packages []string `bson:"packages,omitempty" json:"packages,omitempty" yaml:"packages,omitempty"`
+	// Directory is the directory that the archive is extracted
+	// into, or empty if the archive is not extracted.
+	Directory string `bson:"directory,omitempty" json:"directory,omitempty" yaml:"directory,omitempty"`
+	// Downloaded is true if the archive was downloaded, and false
+	// if an existing copy was reused.
+	Downloaded bool `bson:"downloaded" json:"downloaded" yaml:"downloaded"`
+	// JobID is the ID of the job that downloaded the archive.
+	JobID string `bson:"job_id,omitempty" json:"job_id,omitempty" yaml:"job_id,omitempty"`
+	// Metadata is the version and git hash that the extracted build
+	// reports, when known. For nightly ("latest") builds, this
+	// identifies the snapshot that the archive contains.
+	Metadata bond.BuildMetadata `bson:"metadata,omitempty" json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// Fetch downloads and extracts the archives of every release for
+// every build, and returns the archives that it resolved. All of the
+// downloads share one queue, and archives that several builds or
+// releases resolve to are only downloaded once. Releases
+// that cannot be resolved in the feed cause Fetch to return an error
+// before downloading anything. When downloads fail, Fetch returns
+// the results alongside the error.
+//
+// Archives with known checksums are verified, whether they are
+// downloaded or reused, and archives that don't match return an error
+// that wraps ErrChecksumMismatch. Failed downloads return an error
+// that wraps a *bond.DownloadError, and releases or builds that are
+// not in the feed return errors that wrap bond.ErrVersionNotFound or
+// bond.ErrBuildNotFound.
+//
+// Download jobs have deterministic IDs, so calling Fetch again with
+// the same persistent queue resumes an interrupted fetch: jobs that
+// are already in the queue are not added again, and Fetch waits for
+// them to complete. Jobs that completed with an error, or whose
+// archive or directory has since been removed, are replaced by new
+// jobs that download the archive again.
+//
+// In offline mode, Fetch uses only the cached feed and the archives
+// that are already downloaded, and returns an error naming every
+// archive that it would have downloaded.
+func Fetch(ctx context.Context, opts FetchOptions) ([]FetchResult, error) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid fetch options")
+	}
+	if opts.Queue != nil && bond.HasProgressReporter(ctx) {
+		return nil, errors.Wrap(bond.ErrInvalidOptions, "invalid fetch options: cannot report the progress of downloads in a queue")
+	}
+
+	results, err := resolveFetchResults(ctx, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving builds")
+	}
+
+	if bond.IsOffline(ctx) {
+		if err = useCachedArchives(ctx, results); err != nil {
+			return results, errors.Wrap(err, "using cached archives")
+		}
+		addBuildMetadata(results)
+		if err = verifyBuildMetadata(results); err != nil {
+			return results, errors.Wrap(err, "verifying builds")
+		}
+		return results, writeManifest(opts, results)
+	}
+
+	jobCtx, err := opts.Limits.apply(parent)
+	if err != nil {
+		return nil, errors.Wrap(err, "applying download limits")
+	}
+
+	progress := bond.NewProgressAggregator(bond.GetProgressReporter(jobCtx))
+	jobCtx = bond.WithProgressReporter(jobCtx, progress)
+
+	q := opts.Queue
+	if q == nil {
+		q = queue.NewLocalLimitedSize(opts.Limits.workers(), opts.queueSize())
+		var queueCancel context.CancelFunc
+		jobCtx, queueCancel = context.WithCancel(jobCtx)
+		defer queueCancel()
+	}
+	if !q.Info().Started {
+		if err = q.Start(jobCtx); err != nil {
+			return nil, errors.Wrap(err, "starting queue")
+		}
+	}
+
+	urls := make(chan string, len(results))
+	for _, res := range results {
+		if len(res.Packages) == 0 {
+			urls <- res.URL
+		}
+	}
+	close(urls)
+
+	catcher := grip.NewCatcher()
+	checksums := map[string]string{}
+	for _, res := range results {
+		checksums[res.URL] = res.Sha256
+	}
+
+	jobs, errs := createJobs(opts.Path, opts.Force, urls)
+	ids := map[string]string{}
+	for j := range jobs {
+		j.SkipExtract = opts.SkipExtract
+		j.Sha256 = checksums[j.URL]
+
+		id, err := putJob(ctx, q, j)
+		if err != nil {
+			catcher.Wrapf(err, "adding job for '%s' to queue", j.URL)
+			continue
+		}
+		ids[j.URL] = id
+	}
+	catcher.Add(aggregateErrors(errs))
+
+	for _, res := range results {
+		if len(res.Packages) == 0 {
+			continue
+		}
+
+		j, err := NewDownloadPackagesJob(res.Packages, opts.Path, filepath.Base(res.Directory), opts.Force)
+		if err != nil {
+			catcher.Wrapf(err, "problem generating task for %s", res.URL)
+			continue
+		}
+
+		id, err := putJob(ctx, q, j)
+		if err != nil {
+			catcher.Wrapf(err, "adding job for '%s' to queue", res.URL)
+			continue
+		}
+		ids[res.URL] = id
+	}
+	if catcher.HasErrors() {
+		return nil, errors.Wrap(bond.ResolveErrors(catcher), "populating jobs")
+	}
+
+	grip.Debugf(ctx, "waiting for %d download jobs to complete", len(ids))
+	waitForJobs(ctx, q, ids, progress)
+	grip.Debug(ctx, "all download tasks complete, processing errors now")
+
+	for idx := range results {
+		id := ids[results[idx].URL]
+		results[idx].JobID = id
+
+		j, ok := q.Get(ctx, id)
+		if !ok {
+			catcher.Errorf("download job '%s' is not in the queue", id)
+			continue
+		}
+		switch dj := j.(type) {
+		case *DownloadFileJob:
+			results[idx].Downloaded = dj.Downloaded
+			if dj.ChecksumMismatch {
+				catcher.Add(errors.Wrapf(ErrChecksumMismatch, "job '%s': %s", id, j.Error()))
+				continue
+			}
+			if dj.DownloadFailed {
+				catcher.Wrapf(&bond.DownloadError{URL: dj.URL, StatusCode: dj.StatusCode, Err: j.Error()}, "job '%s'", id)
+				continue
+			}
+		case *DownloadPackagesJob:
+			results[idx].Downloaded = dj.Downloaded
+			if dj.DownloadFailed {
+				catcher.Wrapf(&bond.DownloadError{URL: dj.FailedURL, StatusCode: dj.StatusCode, Err: j.Error()}, "job '%s'", id)
+				continue
+			}
+		}
+		catcher.Wrapf(j.Error(), "job '%s'", id)
+
+		if !results[idx].Downloaded && results[idx].Sha256 != "" && j.Error() == nil {
+			catcher.Add(verifyChecksum(results[idx].Archive, results[idx].Sha256))
+		}
+	}
+	catcher.Wrap(ctx.Err(), "waiting for download jobs")
+
+	if catcher.HasErrors() {
+		return results, errors.Wrap(bond.ResolveErrors(catcher), "resolving download job errors")
+	}
+
+	addBuildMetadata(results)
+	if err = verifyBuildMetadata(results); err != nil {
+		return results, errors.Wrap(err, "verifying builds")
+	}
+	return results, writeManifest(opts, results)
+}
+
+// resumableJob is a download job that Fetch can resume, or replace
+// with a new attempt.
+type resumableJob interface {
+	amboy.Job
+	// filesExist returns true if the files that the job creates
+	// exist.
+	filesExist() bool
+	// retry returns a new job with the given ID that downloads the
+	// files again.
+	retry(id string) (amboy.Job, error)
+}
+
+// putJob adds the job to the queue, or if the queue already has a job
+// with the same ID, resumes or replaces it (see resumeJob), and
+// returns the ID of the job to wait for.
+func putJob(ctx context.Context, q amboy.Queue, j resumableJob) (string, error) {
+	err := q.Put(ctx, j)
+	if amboy.IsDuplicateJobError(err) {
+		return resumeJob(ctx, q, j)
+	}
+	return j.ID(), err
+}
+
+// resumeJob returns the ID of the job to wait for when the queue
+// already has a job with the same ID as j. Pending and running jobs
+// are resumed, as are completed jobs whose files are still in place.
+// Completed jobs that failed, or whose files were removed, are
+// replaced by a new attempt, with the attempt number appended to the
+// ID, that downloads the files again. Later calls resume the latest
+// attempt.
+func resumeJob(ctx context.Context, q amboy.Queue, j resumableJob) (string, error) {
+	id := j.ID()
+	existing, ok := q.Get(ctx, id)
+	attempt := 0
+	for ok {
+		next, found := q.Get(ctx, fmt.Sprintf("%s-%d", j.ID(), attempt+1))
+		if !found {
+			break
+		}
+		existing, id = next, next.ID()
+		attempt++
+	}
+
+	if !ok || !existing.Status().Completed || (existing.Error() == nil && j.filesExist()) {
+		grip.Info(ctx, message.Fields{
+			"message": "resuming existing download job",
+			"job":     id,
+		})
+		return id, nil
+	}
+
+	retry, err := j.retry(fmt.Sprintf("%s-%d", j.ID(), attempt+1))
+	if err != nil {
+		return "", errors.Wrap(err, "creating replacement download job")
+	}
+
+	grip.Info(ctx, message.Fields{
+		"message":  "replacing completed download job",
+		"job":      id,
+		"replaced": retry.ID(),
+		"failed":   existing.Error() != nil,
+	})
+
+	return retry.ID(), errors.Wrap(q.Put(ctx, retry), "adding replacement download job")
+}
+
+// addBuildMetadata adds the metadata recorded in the extracted builds
+// to the results. The git hash of nightly builds, which the feed does
+// not know, is taken from the metadata.
+func addBuildMetadata(results []FetchResult) {
+	for idx := range results {
+		if results[idx].Directory == "" {
+			continue
+		}
+
+		md, err := bond.ReadBuildMetadata(results[idx].Directory)
+		if err != nil {
+			continue
+		}
+
+		results[idx].Metadata = md
+		if results[idx].GitHash == "" {
+			results[idx].GitHash = md.GitHash
+		}
+	}
+}
+
+func writeManifest(opts FetchOptions, results []FetchResult) error {
+	if opts.ManifestFile == "" {
+		return nil
+	}
+
+	if err := lockChecksums(results); err != nil {
+		return errors.Wrap(err, "computing archive checksums")
+	}
+
+	return errors.Wrap(NewManifest(results).WriteFile(opts.ManifestFile), "writing manifest")
+}
+
+// waitForJobs waits for the jobs to complete, and logs the combined
+// progress of their downloads periodically.
+func waitForJobs(ctx context.Context, q amboy.Queue, ids map[string]string, progress *bond.ProgressAggregator) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	lastLogged := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if jobsComplete(ctx, q, ids) {
+				logProgress(ctx, q, progress)
+				return
+			}
+
+			if time.Since(lastLogged) >= progressLogInterval {
+				lastLogged = time.Now()
+				logProgress(ctx, q, progress)
+			}
+			timer.Reset(100 * time.Millisecond)
+		}
+	}
+}
+
+func jobsComplete(ctx context.Context, q amboy.Queue, ids map[string]string) bool {
+	for _, id := range ids {
+		j, ok := q.Get(ctx, id)
+		if !ok {
+			// missing jobs are reported with the results.
+			continue
+		}
+		if !j.Status().Completed || j.RetryInfo().ShouldRetry() {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveFetchResults resolves the archives of every release for
+// every build in the feed, omitting duplicate archives. The archives
+// in a manifest are used as they are, without the feed.
+func resolveFetchResults(ctx context.Context, opts FetchOptions) ([]FetchResult, error) {
+	if opts.Manifest != nil {
+		return resolveManifestResults(opts)
+	}
+
+	feed, err := bond.NewArtifactsFeed(opts.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "building feed")
+	}
+	if err = feed.Populate(ctx, opts.feedTTL()); err != nil {
+		return nil, errors.Wrap(err, "generating data feed")
+	}
+
+	catcher := grip.NewCatcher()
+	var results []FetchResult
+	seen := map[string]bool{}
+	for _, build := range opts.builds() {
+		for _, rel := range opts.Releases {
+			if opts.Packages {
+				res, err := newPackagesResult(opts, feed, rel, build)
+				if err != nil {
+					catcher.Add(err)
+					continue
+				}
+				if !seen[res.URL] {
+					seen[res.URL] = true
+					results = append(results, res)
+				}
+				continue
+			}
+
+			archives, err := feed.GetReleaseArchives(rel, build)
+			if err != nil {
+				catcher.Add(err)
+				continue
+			}
+
+			for _, archive := range archives {
+				if seen[archive.URL] {
+					continue
+				}
+				seen[archive.URL] = true
+
+				res, err := newFetchResult(opts, rel, archive)
+				if err != nil {
+					catcher.Wrapf(err, "problem with url '%s'", archive.URL)
+					continue
+				}
+				results = append(results, res)
+			}
+		}
+	}
+
+	return results, bond.ResolveErrors(catcher)
+}
+
+func resolveManifestResults(opts FetchOptions) ([]FetchResult, error) {
+	catcher := grip.NewCatcher()
+	var results []FetchResult
+	seen := map[string]bool{}
+	for _, entry := range opts.Manifest.Builds {
+		if seen[entry.URL] {
+			continue
+		}
+		seen[entry.URL] = true
+
+		res, err := newFetchResult(opts, entry.Release, bond.ArchiveBuild{
+			Build:   bond.BuildInfo{Version: entry.Version, Options: entry.Options},
+			URL:     entry.URL,
+			Sha256:  entry.Sha256,
+			GitHash: entry.GitHash,
+		})
+		if err != nil {
+			catcher.Wrapf(err, "problem with url '%s'", entry.URL)
+			continue
+		}
+		if len(entry.Packages) > 0 {
+			res.usePackages(entry.Packages)
+		}
+		results = append(results, res)
+	}
+
+	return results, bond.ResolveErrors(catcher)
+}
+
+func newFetchResult(opts FetchOptions, release string, archive bond.ArchiveBuild) (FetchResult, error) {
+	j := newDownloadJob()
+	if err := j.setURL(archive.URL); err != nil {
+		return FetchResult{}, err
+	}
+	j.Directory = opts.Path
+
+	res := FetchResult{
+		Release: release,
+		Build:   archive.Build,
+		URL:     archive.URL,
+		Sha256:  archive.Sha256,
+		GitHash: archive.GitHash,
+		Archive: j.getFileName(),
+	}
+	if !opts.SkipExtract {
+		res.Directory = archiveBaseName(res.Archive)
+	}
+
+	return res, nil
+}
+
+// newPackagesResult resolves the packages of a release for a build.
+func newPackagesResult(opts FetchOptions, feed *bond.ArtifactsFeed, release string, build bond.BuildOptions) (FetchResult, error) {
+	version, dl, err := feed.GetReleaseDownload(release, build)
+	if err != nil {
+		return FetchResult{}, err
+	}
+
+	packages := dl.GetPackages()
+	if len(packages) == 0 {
+		return FetchResult{}, errors.Wrapf(bond.ErrBuildNotFound, "no packages defined for release '%s' with options %s", release, build)
+	}
+
+	res, err := newFetchResult(opts, release, bond.ArchiveBuild{
+		Build:   bond.BuildInfo{Version: version.Version, Options: build},
+		URL:     dl.Archive.URL,
+		GitHash: version.GitHash,
+	})
+	if err != nil {
+		return FetchResult{}, errors.Wrapf(err, "problem with url '%s'", dl.Archive.URL)
+	}
+	res.usePackages(packages)
+
+	return res, nil
+}
+
+// usePackages fetches the result from packages rather than its
+// archive. Packages are extracted into a directory named like the
+// archive, so they're indistinguishable from archives in a catalog.
+func (res *FetchResult) usePackages(packages []string) {
+	res.Directory = archiveBaseName(res.Archive)
+	res.Archive = ""
+	res.Sha256 = ""
+	res.Packages = packages
+}
+
+// useCachedArchives extracts the archives that are downloaded but not
+// yet extracted, without accessing the network, and returns an error
+// that names each archive that is not downloaded.
+func useCachedArchives(ctx context.Context, results []FetchResult) error {
+	catcher := grip.NewCatcher()
+	for _, res := range results {
+		if len(res.Packages) > 0 {
+			catcher.Add(useCachedPackages(ctx, res))
+			continue
+		}
+
+		if _, err := os.Stat(res.Archive); os.IsNotExist(err) {
+			if res.Directory != "" {
+				if _, err = os.Stat(res.Directory); err == nil {
+					continue
+				}
+			}
+			catcher.Add(&bond.OfflineError{URL: res.URL, Path: res.Archive})
+			continue
+		}
+
+		if res.Sha256 != "" {
+			if err := verifyChecksum(res.Archive, res.Sha256); err != nil {
+				catcher.Add(err)
+				continue
+			}
+		}
+
+		if res.Directory == "" {
+			continue
+		}
+		if _, err := os.Stat(res.Directory); err == nil {
+			continue
+		}
+		catcher.Add(extractBuild(ctx, res.Archive))
+	}
+
+	return bond.ResolveErrors(catcher)
+}
+
+// useCachedPackages extracts packages that are downloaded but not yet
+// extracted, and returns an error that names each package that is
+// not downloaded.
+func useCachedPackages(ctx context.Context, res FetchResult) error {
+	if _, err := os.Stat(res.Directory); err == nil {
+		return nil
+	}
+
+	j, err := NewDownloadPackagesJob(res.Packages, filepath.Dir(res.Directory), filepath.Base(res.Directory), false)
+	if err != nil {
+		return err
+	}
+
+	catcher := grip.NewCatcher()
+	for _, url := range res.Packages {
+		fn := j.getPackageFileName(url)
+		if _, err := os.Stat(fn); os.IsNotExist(err) {
+			catcher.Add(&bond.OfflineError{URL: url, Path: fn})
+		}
+	}
+	if catcher.HasErrors() {
+		return bond.ResolveErrors(catcher)
+	}
+
+	j.Run(ctx)
+	return j.Error()
+}
@@ -0,0 +1,553 @@
+package recall
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/bond"
+	"github.com/mongodb/amboy/queue"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFetchServer serves build archives, and returns a directory
+// with a cached feed that refers to them.
+func newTestFetchServer(t *testing.T, requests *int32) (*httptest.Server, string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		name := filepath.Base(r.URL.Path)
+		name = name[:len(name)-len(".tgz")]
+		w.Header().Set("ETag", `"`+name+`"`)
+		// nightly archives are named after their version.
+		root := strings.Replace(name, "v4.4-latest", "4.4.2-rc0-12-gabc1234", 1)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(gzipBytes(t, makeTestTar(t, map[string]string{
+			root + "/bin/mongod": "mongod",
+		}))))
+	}))
+	t.Cleanup(srv.Close)
+
+	var versions []string
+	for _, v := range []string{"4.4.1", "4.4.0"} {
+		versions = append(versions, fmt.Sprintf(`{
+  "version": %q,
+  "downloads": [
+    {"arch": "x86_64", "edition": "base", "target": "linux_x86_64", "archive": {"url": "%s/mongodb-linux-x86_64-%s.tgz"}},
+    {"arch": "x86_64", "edition": "enterprise", "target": "rhel80", "archive": {"url": "%s/mongodb-linux-x86_64-enterprise-rhel80-%s.tgz"}}
+  ]
+}`, v, srv.URL, v, srv.URL, v))
+	}
+
+	dir := t.TempDir()
+	feed := fmt.Sprintf(`{"versions": [%s, %s]}`, versions[0], versions[1])
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "full.json"), []byte(feed), 0644))
+
+	return srv, dir
+}
+
+func TestFetch(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	base := bond.BuildOptions{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}
+	enterprise := bond.BuildOptions{Target: "rhel80", Arch: bond.AMD64, Edition: bond.Enterprise}
+
+	var requests int32
+	_, dir := newTestFetchServer(t, &requests)
+	opts := FetchOptions{
+		Releases: []string{"4.4.1", "4.4.0"},
+		Path:     dir,
+		Builds:   []bond.BuildOptions{base, enterprise},
+		FeedTTL:  time.Hour,
+	}
+
+	results, err := Fetch(ctx, opts)
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	assert.EqualValues(t, 4, atomic.LoadInt32(&requests))
+
+	assert.Equal(t, bond.BuildInfo{Version: "4.4.1", Options: base}, results[0].Build)
+	assert.Equal(t, bond.BuildInfo{Version: "4.4.0", Options: enterprise}, results[3].Build)
+	for _, res := range results {
+		assert.True(t, res.Downloaded, res.URL)
+		assert.Equal(t, filepath.Join(dir, filepath.Base(res.URL)), res.Archive)
+		assert.Equal(t, archiveBaseName(res.Archive), res.Directory)
+		_, err = os.Stat(filepath.Join(res.Directory, "bin", "mongod"))
+		assert.NoError(t, err)
+	}
+
+	t.Run("ReusesDownloads", func(t *testing.T) {
+		results, err := Fetch(ctx, opts)
+		require.NoError(t, err)
+		require.Len(t, results, 4)
+		for _, res := range results {
+			assert.False(t, res.Downloaded, res.URL)
+		}
+	})
+	t.Run("Force", func(t *testing.T) {
+		forced := opts
+		forced.Builds = []bond.BuildOptions{base}
+		forced.Releases = []string{"4.4.1"}
+		forced.Force = true
+
+		results, err := Fetch(ctx, forced)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.True(t, results[0].Downloaded)
+	})
+	t.Run("UnresolvedReleases", func(t *testing.T) {
+		before := atomic.LoadInt32(&requests)
+		invalid := opts
+		invalid.Releases = []string{"4.4.1", "3.2.0"}
+
+		results, err := Fetch(ctx, invalid)
+		assert.Error(t, err)
+		assert.Nil(t, results)
+		assert.Equal(t, before, atomic.LoadInt32(&requests))
+	})
+}
+
+func TestFetchSkipExtract(t *testing.T) {
+	t.Parallel()
+
+	var requests int32
+	_, dir := newTestFetchServer(t, &requests)
+	results, err := Fetch(context.Background(), FetchOptions{
+		Releases:    []string{"4.4.1"},
+		Path:        dir,
+		Builds:      []bond.BuildOptions{{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}},
+		SkipExtract: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Empty(t, results[0].Directory)
+
+	_, err = os.Stat(results[0].Archive)
+	assert.NoError(t, err)
+	_, err = os.Stat(archiveBaseName(results[0].Archive))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFetchPackages(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	build := bond.BuildOptions{Target: "ubuntu2004", Arch: bond.AMD64, Edition: bond.CommunityTargeted}
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(makeTestDeb(t, func(b []byte) []byte { return gzipBytes(t, b) }, "data.tar.gz")))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "full.json"), []byte(fmt.Sprintf(`{"versions": [{
+  "version": "4.4.1",
+  "downloads": [
+    {
+      "arch": "x86_64", "edition": "targeted", "target": "ubuntu2004",
+      "archive": {"url": "%[1]s/mongodb-linux-x86_64-ubuntu2004-4.4.1.tgz"},
+      "packages": ["%[1]s/mongodb-org-server_4.4.1_amd64.deb", "%[1]s/mongodb-org-mongos_4.4.1_amd64.deb"]
+    }
+  ]
+}]}`, srv.URL)), 0644))
+
+	opts := FetchOptions{
+		Releases: []string{"4.4.1"},
+		Path:     dir,
+		Builds:   []bond.BuildOptions{build},
+		FeedTTL:  time.Hour,
+		Packages: true,
+	}
+
+	results, err := Fetch(ctx, opts)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+	assert.True(t, results[0].Downloaded)
+	assert.Empty(t, results[0].Archive)
+	assert.Len(t, results[0].Packages, 2)
+	assert.Equal(t, filepath.Join(dir, "mongodb-linux-x86_64-ubuntu2004-4.4.1"), results[0].Directory)
+	_, err = os.Stat(filepath.Join(results[0].Directory, "bin", "mongod"))
+	assert.NoError(t, err)
+	assert.Equal(t, results[0].Packages, NewManifest(results).Builds[0].Packages)
+
+	t.Run("ReusesDownloads", func(t *testing.T) {
+		results, err := Fetch(ctx, opts)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.False(t, results[0].Downloaded)
+		assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+	})
+	t.Run("Offline", func(t *testing.T) {
+		require.NoError(t, os.RemoveAll(results[0].Directory))
+
+		_, err := Fetch(bond.WithOffline(ctx), opts)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+		_, err = os.Stat(filepath.Join(results[0].Directory, "bin", "mongod"))
+		assert.NoError(t, err)
+	})
+	t.Run("Force", func(t *testing.T) {
+		forced := opts
+		forced.Force = true
+
+		results, err := Fetch(ctx, forced)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.True(t, results[0].Downloaded)
+		assert.EqualValues(t, 4, atomic.LoadInt32(&requests))
+	})
+}
+
+func TestFetchOptionsValidate(t *testing.T) {
+	t.Parallel()
+	build := bond.BuildOptions{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}
+
+	opts := FetchOptions{Builds: []bond.BuildOptions{build}}
+	assert.NoError(t, opts.Validate())
+	assert.Equal(t, defaultFeedTTL, opts.feedTTL())
+	assert.Equal(t, defaultQueueSize, opts.queueSize())
+
+	for name, opts := range map[string]FetchOptions{
+		"NoBuilds":            {},
+		"InvalidBuild":        {Builds: []bond.BuildOptions{{Target: "linux_x86_64"}}},
+		"InvalidLimits":       {Builds: []bond.BuildOptions{build}, Limits: DownloadLimits{Workers: -1}},
+		"NegativeTTL":         {Builds: []bond.BuildOptions{build}, FeedTTL: -time.Second},
+		"NegativeQueue":       {Builds: []bond.BuildOptions{build}, QueueSize: -1},
+		"PackagesSkipExtract": {Builds: []bond.BuildOptions{build}, Packages: true, SkipExtract: true},
+		"PackagesDebug":       {Builds: []bond.BuildOptions{{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base, Debug: true}}, Packages: true},
+		"PackagesManifest":    {Manifest: &Manifest{Builds: []ManifestEntry{{URL: "https://example.net/mongodb.tgz", Options: build}}}, Packages: true},
+	} {
+		assert.Error(t, opts.Validate(), name)
+		_, err := Fetch(context.Background(), opts)
+		assert.Error(t, err, name)
+	}
+
+	t.Run("Queue", func(t *testing.T) {
+		q, err := queue.NewLocalLimitedSizeSerializable(2, 16)
+		require.NoError(t, err)
+
+		opts := FetchOptions{Builds: []bond.BuildOptions{build}, Queue: q}
+		assert.NoError(t, opts.Validate())
+
+		for name, opts := range map[string]FetchOptions{
+			"Limits":    {Builds: []bond.BuildOptions{build}, Queue: q, Limits: DownloadLimits{Workers: 2}},
+			"QueueSize": {Builds: []bond.BuildOptions{build}, Queue: q, QueueSize: 16},
+		} {
+			err := opts.Validate()
+			assert.True(t, errors.Is(err, bond.ErrInvalidOptions), name)
+		}
+
+		ctx := bond.WithProgressReporter(context.Background(), bond.ProgressReporterFunc(func(context.Context, bond.DownloadProgress) {}))
+		_, err = Fetch(ctx, opts)
+		assert.True(t, errors.Is(err, bond.ErrInvalidOptions), "%v", err)
+		assert.False(t, q.Info().Started)
+	})
+}
+
+func TestFetchMatrixDeduplicatesArchives(t *testing.T) {
+	t.Parallel()
+	base := bond.BuildOptions{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}
+
+	var requests int32
+	_, dir := newTestFetchServer(t, &requests)
+	results, err := Fetch(context.Background(), FetchOptions{
+		Releases: []string{"4.4.1", "4.4.0", "4.4.1"},
+		Path:     dir,
+		Builds:   []bond.BuildOptions{base, base},
+		Matrix: bond.BuildMatrix{
+			Targets:  []string{"rhel80"},
+			Arches:   []bond.MongoDBArch{bond.AMD64},
+			Editions: []bond.MongoDBEdition{bond.Enterprise},
+		},
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, atomic.LoadInt32(&requests))
+
+	var builds []bond.BuildInfo
+	for _, res := range results {
+		builds = append(builds, res.Build)
+	}
+	enterprise := bond.BuildOptions{Target: "rhel80", Arch: bond.AMD64, Edition: bond.Enterprise}
+	assert.Equal(t, []bond.BuildInfo{
+		{Version: "4.4.1", Options: base},
+		{Version: "4.4.0", Options: base},
+		{Version: "4.4.1", Options: enterprise},
+		{Version: "4.4.0", Options: enterprise},
+	}, builds)
+
+	opts := FetchOptions{Matrix: bond.BuildMatrix{Targets: []string{"rhel80"}}}
+	assert.Error(t, opts.Validate())
+}
+
+func TestDownloadJobIDs(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	url := "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.1.tgz"
+
+	first, err := NewDownloadJob(url, dir, false)
+	require.NoError(t, err)
+	second, err := NewDownloadJob(url, dir, false)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID(), second.ID())
+
+	other, err := NewDownloadJob("https://mirror.example.com/mongodb-linux-x86_64-4.4.1.tgz", dir, false)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID(), other.ID())
+
+	forced, err := NewDownloadJob(url, dir, true)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID(), forced.ID())
+
+	latest := "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-v4.4-latest.tgz"
+	first, err = NewDownloadJob(latest, dir, false)
+	require.NoError(t, err)
+	second, err = NewDownloadJob(latest, dir, false)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID(), second.ID())
+}
+
+func TestFetchWithQueueResumes(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var requests int32
+	_, dir := newTestFetchServer(t, &requests)
+
+	q, err := queue.NewLocalLimitedSizeSerializable(2, 16)
+	require.NoError(t, err)
+	require.NoError(t, q.Start(ctx))
+
+	opts := FetchOptions{
+		Releases: []string{"4.4.1", "4.4.0"},
+		Path:     dir,
+		Builds:   []bond.BuildOptions{{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}},
+		Queue:    q,
+	}
+
+	results, err := Fetch(ctx, opts)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+	for _, res := range results {
+		assert.NotEmpty(t, res.JobID)
+		assert.True(t, res.Downloaded)
+		_, ok := q.Get(ctx, res.JobID)
+		assert.True(t, ok)
+	}
+
+	// the jobs are already in the queue, so fetching again reuses
+	// them rather than adding new jobs.
+	resumed, err := Fetch(ctx, opts)
+	require.NoError(t, err)
+	assert.Equal(t, results, resumed)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+	assert.Equal(t, 2, q.Stats(ctx).Total)
+}
+
+func TestFetchWithQueueReplacesCompletedJobs(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var requests int32
+	var failing int32 = 1
+	srv, dir := newTestFetchServer(t, &requests)
+	handler := srv.Config.Handler
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	q, err := queue.NewLocalLimitedSizeSerializable(2, 16)
+	require.NoError(t, err)
+	require.NoError(t, q.Start(ctx))
+
+	opts := FetchOptions{
+		Releases: []string{"4.4.1"},
+		Path:     dir,
+		Builds:   []bond.BuildOptions{{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}},
+		Queue:    q,
+	}
+
+	results, err := Fetch(ctx, opts)
+	require.Error(t, err)
+	var dl *bond.DownloadError
+	require.True(t, errors.As(err, &dl), err.Error())
+	assert.Equal(t, http.StatusInternalServerError, dl.StatusCode)
+	require.Len(t, results, 1)
+	failedID := results[0].JobID
+
+	t.Run("FailedJobRetries", func(t *testing.T) {
+		atomic.StoreInt32(&failing, 0)
+		results, err = Fetch(ctx, opts)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, failedID+"-1", results[0].JobID)
+		assert.True(t, results[0].Downloaded)
+		assert.DirExists(t, results[0].Directory)
+		assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+	})
+	t.Run("RemovedFilesDownloadAgain", func(t *testing.T) {
+		require.NoError(t, os.Remove(results[0].Archive))
+		require.NoError(t, os.RemoveAll(results[0].Directory))
+
+		again, err := Fetch(ctx, opts)
+		require.NoError(t, err)
+		require.Len(t, again, 1)
+		assert.Equal(t, failedID+"-2", again[0].JobID)
+		assert.True(t, again[0].Downloaded)
+		assert.FileExists(t, again[0].Archive)
+		assert.DirExists(t, again[0].Directory)
+		assert.EqualValues(t, 3, atomic.LoadInt32(&requests))
+	})
+	t.Run("CompletedJobResumes", func(t *testing.T) {
+		again, err := Fetch(ctx, opts)
+		require.NoError(t, err)
+		require.Len(t, again, 1)
+		assert.Equal(t, failedID+"-2", again[0].JobID)
+		assert.EqualValues(t, 3, atomic.LoadInt32(&requests))
+	})
+}
+
+func TestFetchLatestRevalidates(t *testing.T) {
+	ctx := context.Background()
+	base := bond.BuildOptions{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}
+
+	var requests int32
+	_, dir := newTestFetchServer(t, &requests)
+	opts := FetchOptions{
+		Releases: []string{"4.4-latest"},
+		Path:     dir,
+		Builds:   []bond.BuildOptions{base},
+	}
+
+	results, err := Fetch(ctx, opts)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Downloaded)
+	assert.Equal(t, "4.4-latest", results[0].Build.Version)
+	assert.Equal(t, filepath.Join(dir, "mongodb-linux-x86_64-v4.4-latest.tgz"), results[0].Archive)
+	assert.DirExists(t, results[0].Directory)
+	assert.Equal(t, bond.BuildMetadata{
+		Version: "4.4.2-rc0-12-gabc1234",
+		GitHash: "abc1234",
+		Source:  bond.MetadataSourceArchive,
+	}, results[0].Metadata)
+	assert.Equal(t, "abc1234", results[0].GitHash)
+
+	// constructing a job doesn't remove the existing build.
+	_, err = NewDownloadJob(results[0].URL, dir, false)
+	require.NoError(t, err)
+	assert.FileExists(t, results[0].Archive)
+	assert.DirExists(t, results[0].Directory)
+
+	// the metadata that mongod reports is preferred.
+	md := bond.BuildMetadata{Version: "4.4.19-rc0", GitHash: "8c7cd5b9ac1e6ea7dd3bcdc6ba6bfa31c4bfd1ad", Source: bond.MetadataSourceBinary}
+	require.NoError(t, bond.WriteBuildMetadata(results[0].Directory, md))
+
+	results, err = Fetch(ctx, opts)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.False(t, results[0].Downloaded)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+	assert.Equal(t, md, results[0].Metadata)
+	assert.Equal(t, md.GitHash, results[0].GitHash)
+	assert.Equal(t, md.GitHash, NewManifest(results).Builds[0].GitHash)
+
+	// a refreshed archive that fails verification doesn't replace
+	// the existing build.
+	_, err = Fetch(ctx, FetchOptions{
+		Path:  dir,
+		Force: true,
+		Manifest: &Manifest{Builds: []ManifestEntry{{
+			Release: "4.4-latest",
+			Version: "4.4-latest",
+			Options: base,
+			URL:     results[0].URL,
+			Sha256:  strings.Repeat("0", 64),
+		}}},
+	})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), err.Error())
+	assert.EqualValues(t, 3, atomic.LoadInt32(&requests))
+	assert.FileExists(t, filepath.Join(results[0].Directory, "bin", "mongod"))
+	reported, err := bond.ReadBuildMetadata(results[0].Directory)
+	require.NoError(t, err)
+	assert.Equal(t, md, reported)
+}
+
+func TestFetchErrors(t *testing.T) {
+	ctx := context.Background()
+	base := bond.BuildOptions{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}
+
+	var requests int32
+	_, dir := newTestFetchServer(t, &requests)
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	for name, test := range map[string]struct {
+		opts     FetchOptions
+		expected error
+	}{
+		"UnknownVersion": {
+			opts:     FetchOptions{Releases: []string{"4.4.1", "9.9.9"}, Path: dir, Builds: []bond.BuildOptions{base}},
+			expected: bond.ErrVersionNotFound,
+		},
+		"NoBuild": {
+			opts: FetchOptions{Releases: []string{"4.4.1"}, Path: dir, Builds: []bond.BuildOptions{
+				{Target: "ubuntu2204", Arch: bond.AMD64, Edition: bond.Enterprise},
+			}},
+			expected: bond.ErrBuildNotFound,
+		},
+		"InvalidOptions": {
+			opts:     FetchOptions{Releases: []string{"4.4.1"}, Path: dir, Builds: []bond.BuildOptions{{Target: "linux_x86_64"}}},
+			expected: bond.ErrInvalidOptions,
+		},
+		"InvalidManifest": {
+			opts:     FetchOptions{Path: dir, Manifest: &Manifest{}},
+			expected: bond.ErrInvalidOptions,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Fetch(ctx, test.opts)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, test.expected), err.Error())
+		})
+	}
+
+	t.Run("DownloadFailed", func(t *testing.T) {
+		_, err := Fetch(ctx, FetchOptions{
+			Path: t.TempDir(),
+			Manifest: &Manifest{Builds: []ManifestEntry{{
+				Release: "4.4.1",
+				Version: "4.4.1",
+				Options: base,
+				URL:     missing.URL + "/mongodb-linux-x86_64-4.4.1.tgz",
+			}}},
+		})
+		require.Error(t, err)
+
+		var dl *bond.DownloadError
+		require.True(t, errors.As(err, &dl), err.Error())
+		assert.Equal(t, http.StatusNotFound, dl.StatusCode)
+		assert.Equal(t, missing.URL+"/mongodb-linux-x86_64-4.4.1.tgz", dl.URL)
+	})
+
+	assert.Equal(t, bond.ErrChecksumMismatch, ErrChecksumMismatch)
+}
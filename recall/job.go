@@ -1,19 +1,15 @@
 package recall
 
 import (
-	"archive/tar"
-	"archive/zip"
-	"compress/gzip"
 	"context"
+	"crypto/sha1"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/evergreen-ci/bond"
-	"github.com/mholt/archiver/v3"
 	"github.com/mongodb/amboy"
 	"github.com/mongodb/amboy/dependency"
 	"github.com/mongodb/amboy/job"
@@ -26,9 +22,24 @@ import (
 // DownloadFileJob is an amboy.Job implementation that supports
 // downloading a a file to the local file system.
 type DownloadFileJob struct {
-	URL       string `bson:"url" json:"url" yaml:"url"`
-	Directory string `bson:"dir" json:"dir" yaml:"dir"`
-	FileName  string `bson:"file" json:"file" yaml:"file"`
+	URL         string `bson:"url" json:"url" yaml:"url"`
+	Directory   string `bson:"dir" json:"dir" yaml:"dir"`
+	FileName    string `bson:"file" json:"file" yaml:"file"`
+	SkipExtract bool   `bson:"skip_extract" json:"skip_extract" yaml:"skip_extract"`
+	// Sha256, if set, is the expected checksum of the file.
+	// ChecksumMismatch is set when the downloaded file doesn't
+	// match it, since job errors are only recorded as strings.
+	Sha256           string `bson:"sha256,omitempty" json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	ChecksumMismatch bool   `bson:"checksum_mismatch" json:"checksum_mismatch" yaml:"checksum_mismatch"`
+	// DownloadFailed is set when the request for the file fails,
+	// and StatusCode is the status of the failed response, if any.
+	DownloadFailed bool `bson:"download_failed" json:"download_failed" yaml:"download_failed"`
+	StatusCode     int  `bson:"status_code,omitempty" json:"status_code,omitempty" yaml:"status_code,omitempty"`
+	// Downloaded is set when the job downloads the file, rather than
+	// reusing an existing copy.
+	Downloaded bool `bson:"downloaded" json:"downloaded" yaml:"downloaded"`
+	// Force downloads the file again, even if it exists.
+	Force     bool `bson:"force" json:"force" yaml:"force"`
 	*job.Base `bson:"metadata" json:"metadata" yaml:"metadata"`
 }
 
@@ -52,6 +63,15 @@ func newDownloadJob() *DownloadFileJob {
 // NewDownloadJob constructs a DownloadFileJob. The job has a
 // dependency on the downloaded file, and will only execute if that
 // file does not exist.
+//
+// Jobs that download the same URL into the same directory have the
+// same ID, so that a fetch on a persistent queue can be resumed (see
+// Fetch for how completed jobs are handled).
+// Forced jobs and jobs for nightly ("latest") builds always run, and
+// have unique IDs. Forced jobs download the file again, and nightly
+// builds are only downloaded again if they have changed. In both
+// cases, the existing file is only replaced once the new copy is
+// downloaded.
 func NewDownloadJob(url, path string, force bool) (*DownloadFileJob, error) {
 	j := newDownloadJob()
 	if err := j.setURL(url); err != nil {
@@ -63,13 +83,13 @@ func NewDownloadJob(url, path string, force bool) (*DownloadFileJob, error) {
 	}
 
 	fn := j.getFileName()
-	j.SetID(fmt.Sprintf("%s-%d",
+	j.SetID(fmt.Sprintf("%s-%x",
 		strings.Replace(fn, string(filepath.Separator), "-", -1),
-		job.GetNumber()))
+		sha1.Sum([]byte(url))))
 
-	if force || strings.Contains(fn, "latest") {
-		_ = os.Remove(fn)
-		_ = os.RemoveAll(fn[:len(fn)-4])
+	j.Force = force
+	if j.refresh() {
+		j.SetID(fmt.Sprintf("%s-%d", j.ID(), time.Now().UnixNano()))
 		j.SetDependency(dependency.NewAlways())
 	} else {
 		j.SetDependency(dependency.NewCreatesFile(fn))
@@ -98,17 +118,77 @@ func (j *DownloadFileJob) Run(ctx context.Context) {
 		return
 	}
 
+	if j.refresh() {
+		j.refreshFile(ctx)
+		return
+	}
+
 	if err := bond.DownloadFile(ctx, j.URL, fn); err != nil {
+		j.recordDownloadError(err)
 		j.handleError(errors.Wrapf(err, "downloading file '%s'", fn))
 		return
 	}
+	j.Downloaded = true
+
+	j.processFile(ctx)
+}
+
+// refreshFile downloads the file if it doesn't exist, if the job is
+// forced, or if the file has changed. Failed downloads leave the
+// existing file in place, and the existing build is only replaced
+// once the new file is verified and extracted.
+func (j *DownloadFileJob) refreshFile(ctx context.Context) {
+	fn := j.getFileName()
+
+	downloaded, err := bond.RefreshDownload(ctx, j.URL, fn, j.Force)
+	if err != nil {
+		j.recordDownloadError(err)
+		err = errors.Wrapf(err, "downloading file '%s'", fn)
+		j.AddError(err)
+		grip.Error(ctx, message.WrapError(err, message.Fields{
+			"message": "problem refreshing file",
+			"name":    j.FileName,
+		}))
+		return
+	}
+	j.Downloaded = downloaded
+
+	if !downloaded {
+		if _, err = os.Stat(archiveBaseName(fn)); j.SkipExtract || err == nil {
+			grip.Debug(ctx, message.Fields{
+				"file":    fn,
+				"message": "file has not changed",
+				"op":      "none",
+			})
+			return
+		}
+	}
+
+	j.processFile(ctx)
+}
+
+// processFile verifies and extracts the downloaded file.
+func (j *DownloadFileJob) processFile(ctx context.Context) {
+	fn := j.getFileName()
+
+	if j.Sha256 != "" {
+		if err := verifyChecksum(fn, j.Sha256); err != nil {
+			j.ChecksumMismatch = errors.Is(err, ErrChecksumMismatch)
+			j.handleError(errors.Wrapf(err, "verifying file '%s'", fn))
+			return
+		}
+	}
 
 	grip.Debug(ctx, message.Fields{
 		"op":   "downloaded file complete",
 		"file": fn,
 	})
 
-	if err := extractArchive(fn); err != nil {
+	if j.SkipExtract {
+		return
+	}
+
+	if err := extractBuild(ctx, fn); err != nil {
 		j.handleError(errors.Wrapf(err, "extracting artifacts '%s'", fn))
 		return
 	}
@@ -118,81 +198,44 @@ func (j *DownloadFileJob) Run(ctx context.Context) {
 // Internal Methods
 //
 
-func extractArchive(fn string) error {
-	dir := filepath.Dir(fn)
-	baseName := filepath.Base(fn)
-	baseName = baseName[:len(baseName)-4]
-
-	if filepath.Ext(fn) == ".tgz" {
-		// there is no tar.gz because we renamed it in setURL()
-		if err := archiver.NewTarGz().Unarchive(fn, dir); err != nil {
-			return errors.Wrap(err, "extracting archive")
-		}
+// extractBuild extracts a build's archive, and records the build's
+// metadata in the extracted directory.
+func extractBuild(ctx context.Context, fn string) error {
+	root, err := extractArchiveRoot(fn)
+	if err != nil {
+		return err
+	}
 
-		f, err := os.Open(fn)
-		if err != nil {
-			return errors.Wrap(err, "opening file")
-		}
-		defer f.Close()
+	recordBuildMetadata(ctx, archiveBaseName(fn), root)
+	return nil
+}
 
-		gzr, err := gzip.NewReader(f)
-		if err != nil {
-			return errors.Wrap(err, "reading gzip")
-		}
-		defer gzr.Close()
-
-		archive := tar.NewReader(gzr)
-		for {
-			header, err := archive.Next()
-			if err == io.EOF {
-				return errors.Wrap(err, "reading archive contents")
-			}
-			if strings.HasSuffix(header.Name, "mongod") {
-				dirName := filepath.Dir(filepath.Dir(header.Name))
-				if baseName != dirName {
-					if err := os.Rename(filepath.Join(dir, dirName),
-						filepath.Join(dir, baseName)); err != nil {
-
-						return errors.Wrapf(err, "renaming directory '%s' to '%s'", dirName, baseName)
-					}
-				}
-				break
-			}
+// recordBuildMetadata stores the version and git hash reported by
+// the extracted mongod binary in the build directory. Builds for
+// other platforms cannot report this information, so for nightly
+// builds the metadata is taken from the name of the archive's root
+// directory (root) instead. Failures are logged but do not impact
+// the job's error state.
+func recordBuildMetadata(ctx context.Context, dir, root string) {
+	md, err := bond.CollectBuildMetadata(ctx, dir)
+	if err != nil {
+		grip.Debug(ctx, message.WrapError(err, message.Fields{
+			"dir": dir,
+			"op":  "collecting build metadata",
+		}))
+
+		if root == "" {
+			return
 		}
-	} else if filepath.Ext(fn) == ".zip" {
-		if err := archiver.NewZip().Unarchive(fn, dir); err != nil {
-			return errors.Wrap(err, "extracting archive")
+		if md, err = bond.ParseArchiveRootName(root); err != nil {
+			return
 		}
-		r, err := zip.OpenReader(fn)
-		if err != nil {
-			return errors.Wrap(err, "parsing archive")
-		}
-		defer r.Close()
-
-		for _, f := range r.File {
-			if strings.HasSuffix(f.Name, "mongod.exe") {
-				// name is generally mongodb-<platform>-<version>/bin/mongo
-				// call filepath.Dir twice to get the magic parts.
-				dirName := filepath.Dir(filepath.Dir(f.Name))
-				if baseName != dirName {
-					if err := os.Rename(filepath.Join(dir, dirName),
-						filepath.Join(dir, baseName)); err != nil {
-
-						return errors.Wrapf(err, "renaming directory '%s' to '%s'", dirName, baseName)
-					}
-				}
-			}
-		}
-	} else {
-		return errors.Errorf("file '%s' is in unsupported archive format", fn)
 	}
 
-	grip.Debug(context.Background(), message.Fields{
-		"file": fn,
-		"op":   "extracted archive",
-	})
-
-	return nil
+	grip.Warning(ctx, message.WrapError(bond.WriteBuildMetadata(dir, md), message.Fields{
+		"dir": dir,
+		"op":  "recording build metadata",
+	}))
 }
 
 func attemptTimestampUpdate(fn string) {
@@ -204,15 +247,24 @@ func attemptTimestampUpdate(fn string) {
 		grip.Debug(context.Background(), err)
 	}
 
-	// hopefully directory names in archives are the same are the
-	// same as the filenames. Unwinding this assumption will
-	// probably require a different archiver tool.
-	dirname := fn[0 : len(fn)-len(filepath.Ext(fn))]
+	// archives are extracted into a directory named after the
+	// archive.
+	dirname := archiveBaseName(fn)
 	if err := os.Chtimes(dirname, now, now); err != nil {
 		grip.Debug(context.Background(), err)
 	}
 }
 
+// recordDownloadError records whether the error is a failed request,
+// since job errors are only recorded as strings.
+func (j *DownloadFileJob) recordDownloadError(err error) {
+	var dl *bond.DownloadError
+	if errors.As(err, &dl) {
+		j.DownloadFailed = true
+		j.StatusCode = dl.StatusCode
+	}
+}
+
 func (j *DownloadFileJob) handleError(err error) {
 	j.AddError(err)
 
@@ -224,6 +276,39 @@ func (j *DownloadFileJob) handleError(err error) {
 	grip.Warning(context.Background(), os.RemoveAll(j.getFileName())) // cleanup
 }
 
+// retry creates a forced copy of the job with the given ID.
+func (j *DownloadFileJob) retry(id string) (amboy.Job, error) {
+	retry, err := NewDownloadJob(j.URL, j.Directory, true)
+	if err != nil {
+		return nil, err
+	}
+	retry.SkipExtract = j.SkipExtract
+	retry.Sha256 = j.Sha256
+	retry.SetID(id)
+	return retry, nil
+}
+
+// filesExist returns true if the downloaded file exists and, unless
+// the job skips extraction, so does the extracted directory.
+func (j *DownloadFileJob) filesExist() bool {
+	fn := j.getFileName()
+	if _, err := os.Stat(fn); err != nil {
+		return false
+	}
+	if j.SkipExtract {
+		return true
+	}
+
+	_, err := os.Stat(archiveBaseName(fn))
+	return err == nil
+}
+
+// refresh returns true for forced jobs and jobs that download nightly
+// builds, which check for a new copy of the file when it exists.
+func (j *DownloadFileJob) refresh() bool {
+	return j.Force || strings.Contains(j.FileName, "latest")
+}
+
 func (j *DownloadFileJob) getFileName() string {
 	return filepath.Join(j.Directory, j.FileName)
 }
@@ -241,18 +326,18 @@ func (j *DownloadFileJob) setDirectory(path string) error {
 
 func (j *DownloadFileJob) setURL(url string) error {
 	if !strings.HasPrefix(url, "http") {
-		return errors.Errorf("'%s' is not a valid url", url)
+		return errors.Wrapf(bond.ErrInvalidOptions, "'%s' is not a valid url", url)
 	}
 
 	if strings.HasSuffix(url, "/") {
-		return errors.Errorf("'%s' does not contain a valid filename component", url)
+		return errors.Wrapf(bond.ErrInvalidOptions, "'%s' does not contain a valid filename component", url)
 	}
 
 	j.URL = url
 	j.FileName = filepath.Base(url)
 
 	if strings.HasSuffix(url, ".tar.gz") {
-		j.FileName = filepath.Ext(filepath.Ext(j.FileName)) + ".tgz"
+		j.FileName = strings.TrimSuffix(j.FileName, ".tar.gz") + ".tgz"
 	}
 
 	return nil
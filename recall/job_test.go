@@ -3,11 +3,14 @@ package recall
 import (
 	"context"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/evergreen-ci/bond"
 	"github.com/mongodb/amboy"
 	"github.com/mongodb/amboy/dependency"
 	"github.com/mongodb/amboy/registry"
@@ -129,6 +132,19 @@ func (s *DownloadJobSuite) TestTarGzExtensionSpecialCase() {
 		s.NoError(s.job.setURL(path))
 		s.NotEqual("", s.job.URL)
 		s.True(strings.HasSuffix(s.job.FileName, ".tgz"))
+		s.Equal("foo.tgz", s.job.FileName)
+	}
+}
+
+func (s *DownloadJobSuite) TestOtherArchiveExtensionsKeepTheirNames() {
+	for _, fn := range []string{
+		"mongodb-linux-x86_64-4.4.1.tar.xz",
+		"mongodb-linux-x86_64-4.4.1.tar.zst",
+		"mongodb-linux-x86_64-4.4.1.tar",
+		"mongodb-linux-x86_64-4.4.1.tgz",
+	} {
+		s.NoError(s.job.setURL("http://foo.example.net/bar/" + fn))
+		s.Equal(fn, s.job.FileName)
 	}
 }
 
@@ -274,12 +290,37 @@ func TestJobRegistry(t *testing.T) {
 		names = append(names, n)
 	}
 
-	assert.Len(names, 1)
+	assert.Len(names, 2)
 
-	jobType := "bond-recall-download-file"
-	j, err := registry.GetJobFactory(jobType)
-	job := j()
-	assert.NoError(err)
-	assert.Implements((*amboy.Job)(nil), job)
-	assert.Equal(job.Type().Name, jobType)
+	for _, jobType := range []string{"bond-recall-download-file", "bond-recall-download-packages"} {
+		j, err := registry.GetJobFactory(jobType)
+		assert.NoError(err)
+		job := j()
+		assert.Implements((*amboy.Job)(nil), job)
+		assert.Equal(job.Type().Name, jobType)
+	}
+}
+
+func TestDownloadJobReportsProgress(t *testing.T) {
+	t.Parallel()
+	archive := gzipBytes(t, makeTestTar(t, map[string]string{
+		"mongodb-linux-x86_64-4.4.1/bin/mongod": "mongod",
+	}))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(archive)
+	}))
+	defer srv.Close()
+
+	progress := bond.NewProgressAggregator(nil)
+	ctx := bond.WithProgressReporter(context.Background(), progress)
+
+	j, err := NewDownloadJob(srv.URL+"/mongodb-linux-x86_64-4.4.1.tgz", t.TempDir(), false)
+	require.NoError(t, err)
+	j.Run(ctx)
+	require.NoError(t, j.Error())
+
+	summary := progress.Summary()
+	assert.Equal(t, 1, summary.Downloads)
+	assert.Equal(t, 1, summary.Completed)
+	assert.EqualValues(t, len(archive), summary.Received)
 }
@@ -0,0 +1,185 @@
+package recall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/evergreen-ci/bond"
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+)
+
+// ManifestEntry locks a release to the archive that it resolved to.
+type ManifestEntry struct {
+	// Release is the release as it was requested, e.g. "4.4.1",
+	// "6.0-current" or "4.4-latest".
+	Release string            `bson:"release" json:"release" yaml:"release"`
+	Version string            `bson:"version" json:"version" yaml:"version"`
+	Options bond.BuildOptions `bson:"options" json:"options" yaml:"options"`
+	URL     string            `bson:"url" json:"url" yaml:"url"`
+	Sha256  string            `bson:"sha256,omitempty" json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	GitHash string            `bson:"githash,omitempty" json:"githash,omitempty" yaml:"githash,omitempty"`
+	// Packages are the URLs of the packages that the build was
+	// fetched from, if it was not fetched from its archive.
+	Packages []string `bson:"packages,omitempty" json:"packages,omitempty" yaml:"packages,omitempty"`
+}
+
+// Manifest records the archives that a fetch resolved, so that the
+// fetch can be reproduced exactly by passing the manifest to Fetch.
+type Manifest struct {
+	Builds []ManifestEntry `bson:"builds" json:"builds" yaml:"builds"`
+}
+
+// NewManifest creates a manifest of the results of a fetch. Manifests
+// that Fetch writes record the checksum of every archive that it
+// fetched, including nightly ("latest") builds, which the feed has no
+// checksum for, so replaying the manifest fails if the build changed.
+func NewManifest(results []FetchResult) *Manifest {
+	m := &Manifest{Builds: make([]ManifestEntry, 0, len(results))}
+	for _, res := range results {
+		m.Builds = append(m.Builds, ManifestEntry{
+			Release:  res.Release,
+			Version:  res.Build.Version,
+			Options:  res.Build.Options,
+			URL:      res.URL,
+			Sha256:   res.Sha256,
+			GitHash:  res.GitHash,
+			Packages: res.Packages,
+		})
+	}
+	return m
+}
+
+// ReadManifest reads a JSON manifest.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, errors.Wrap(err, "decoding manifest")
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid manifest")
+	}
+
+	return m, nil
+}
+
+// ReadManifestFile reads a JSON manifest from a file.
+func ReadManifestFile(fn string) (*Manifest, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening manifest '%s'", fn)
+	}
+	defer f.Close()
+
+	return ReadManifest(f)
+}
+
+// Write writes the manifest as JSON.
+func (m *Manifest) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(m), "encoding manifest")
+}
+
+// WriteFile writes the manifest as JSON to a file.
+func (m *Manifest) WriteFile(fn string) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return errors.Wrapf(err, "creating manifest '%s'", fn)
+	}
+
+	catcher := grip.NewCatcher()
+	catcher.Add(m.Write(f))
+	catcher.Add(f.Close())
+	return catcher.Resolve()
+}
+
+// Validate checks that every entry has a URL and valid build options.
+func (m *Manifest) Validate() error {
+	catcher := grip.NewCatcher()
+	catcher.NewWhen(len(m.Builds) == 0, "manifest does not contain any builds")
+	for idx, entry := range m.Builds {
+		catcher.ErrorfWhen(!strings.HasPrefix(entry.URL, "http"), "build %d has invalid url '%s'", idx, entry.URL)
+		catcher.Wrapf(entry.Options.Validate(), "build %d has invalid options", idx)
+	}
+	return bond.ResolveInvalidOptions(catcher)
+}
+
+// lockChecksums sets the checksum of the results that the feed has no
+// checksum for, such as nightly ("latest") builds, to the checksum of
+// the archive that was fetched, so that a manifest of the results
+// locks the exact archive. Results without a local archive are left
+// unlocked.
+func lockChecksums(results []FetchResult) error {
+	catcher := grip.NewCatcher()
+	for idx := range results {
+		if results[idx].Sha256 != "" {
+			continue
+		}
+		if _, err := os.Stat(results[idx].Archive); os.IsNotExist(err) {
+			continue
+		}
+
+		sum, err := fileChecksum(results[idx].Archive)
+		if err != nil {
+			catcher.Add(err)
+			continue
+		}
+		results[idx].Sha256 = sum
+	}
+
+	return bond.ResolveErrors(catcher)
+}
+
+// verifyBuildMetadata checks that the git hash that each extracted
+// build reports matches the git hash from the feed or manifest.
+func verifyBuildMetadata(results []FetchResult) error {
+	catcher := grip.NewCatcher()
+	for _, res := range results {
+		expected, actual := strings.ToLower(res.GitHash), strings.ToLower(res.Metadata.GitHash)
+		if expected == "" || actual == "" {
+			continue
+		}
+
+		catcher.ErrorfWhen(!strings.HasPrefix(expected, actual) && !strings.HasPrefix(actual, expected),
+			"build '%s' has git hash '%s', expected '%s'", res.Directory, res.Metadata.GitHash, res.GitHash)
+	}
+
+	return bond.ResolveErrors(catcher)
+}
+
+// verifyChecksum checks that the sha256 checksum of the file matches
+// the expected checksum.
+func verifyChecksum(fn, expected string) error {
+	actual, err := fileChecksum(fn)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(actual, expected) {
+		return errors.Wrapf(ErrChecksumMismatch, "'%s' has sha256 %s, expected %s", fn, actual, expected)
+	}
+
+	return nil
+}
+
+// fileChecksum returns the hex encoded sha256 checksum of the file.
+func fileChecksum(fn string) (string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return "", errors.Wrapf(err, "opening '%s'", fn)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", errors.Wrapf(err, "reading '%s'", fn)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
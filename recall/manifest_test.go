@@ -0,0 +1,199 @@
+package recall
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/bond"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestReadWrite(t *testing.T) {
+	t.Parallel()
+	opts := bond.BuildOptions{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}
+
+	m := NewManifest([]FetchResult{{
+		Release: "4.4-current",
+		Build:   bond.BuildInfo{Version: "4.4.1", Options: opts},
+		URL:     "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.1.tgz",
+		Sha256:  "abcdef",
+		GitHash: "ad91a93",
+		Archive: "ignored",
+	}})
+	assert.Equal(t, []ManifestEntry{{
+		Release: "4.4-current",
+		Version: "4.4.1",
+		Options: opts,
+		URL:     "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.1.tgz",
+		Sha256:  "abcdef",
+		GitHash: "ad91a93",
+	}}, m.Builds)
+
+	fn := filepath.Join(t.TempDir(), "manifest.json")
+	require.NoError(t, m.WriteFile(fn))
+	read, err := ReadManifestFile(fn)
+	require.NoError(t, err)
+	assert.Equal(t, m, read)
+
+	for name, data := range map[string]string{
+		"Empty":          `{"builds": []}`,
+		"InvalidURL":     `{"builds": [{"url": "mongodb.tgz", "options": {"target": "linux_x86_64", "arch": "x86_64", "edition": "base"}}]}`,
+		"InvalidOptions": `{"builds": [{"url": "https://example.com/mongodb.tgz"}]}`,
+		"InvalidJSON":    `{"builds":`,
+	} {
+		_, err = ReadManifest(bytes.NewBufferString(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestFetchWithManifest(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	var requests int32
+	_, dir := newTestFetchServer(t, &requests)
+	manifestFile := filepath.Join(t.TempDir(), "manifest.json")
+
+	results, err := Fetch(ctx, FetchOptions{
+		Releases:     []string{"4.4.1", "4.4.0"},
+		Path:         dir,
+		Builds:       []bond.BuildOptions{{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}},
+		ManifestFile: manifestFile,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	manifest, err := ReadManifestFile(manifestFile)
+	require.NoError(t, err)
+	require.Len(t, manifest.Builds, 2)
+	assert.Equal(t, "4.4.1", manifest.Builds[0].Release)
+	assert.Equal(t, results[0].URL, manifest.Builds[0].URL)
+
+	// the checksums of the downloaded archives are locked.
+	for idx, res := range results {
+		data, err := ioutil.ReadFile(res.Archive)
+		require.NoError(t, err)
+		sum := sha256.Sum256(data)
+		assert.Equal(t, hex.EncodeToString(sum[:]), manifest.Builds[idx].Sha256)
+	}
+
+	t.Run("Reproduce", func(t *testing.T) {
+		// the new directory has no feed, so the archives must
+		// come from the manifest.
+		out := t.TempDir()
+		results, err := Fetch(ctx, FetchOptions{Path: out, Manifest: manifest})
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		for idx, res := range results {
+			assert.Equal(t, manifest.Builds[idx].URL, res.URL)
+			assert.Equal(t, manifest.Builds[idx].Sha256, res.Sha256)
+			assert.True(t, res.Downloaded)
+		}
+		_, err = os.Stat(filepath.Join(out, "full.json"))
+		assert.True(t, os.IsNotExist(err))
+	})
+	t.Run("DownloadedChecksumMismatch", func(t *testing.T) {
+		out := t.TempDir()
+		locked := &Manifest{Builds: []ManifestEntry{manifest.Builds[0]}}
+		locked.Builds[0].Sha256 = "0000"
+
+		results, err := Fetch(ctx, FetchOptions{Path: out, Manifest: locked})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrChecksumMismatch), err.Error())
+		require.Len(t, results, 1)
+
+		_, err = os.Stat(results[0].Archive)
+		assert.True(t, os.IsNotExist(err), "mismatched archives are removed")
+	})
+	t.Run("ReusedChecksumMismatch", func(t *testing.T) {
+		before := atomic.LoadInt32(&requests)
+		locked := &Manifest{Builds: []ManifestEntry{manifest.Builds[0]}}
+		locked.Builds[0].Sha256 = "0000"
+
+		_, err := Fetch(ctx, FetchOptions{Path: dir, Manifest: locked})
+		assert.True(t, errors.Is(err, ErrChecksumMismatch))
+		assert.Equal(t, before, atomic.LoadInt32(&requests))
+	})
+	t.Run("InvalidOptions", func(t *testing.T) {
+		_, err := Fetch(ctx, FetchOptions{Path: dir, Manifest: manifest, Releases: []string{"4.4.1"}})
+		assert.Error(t, err)
+	})
+}
+
+func TestFetchWithLatestManifest(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	var requests int32
+	var changed int32
+	srv, dir := newTestFetchServer(t, &requests)
+	handler := srv.Config.Handler
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&changed) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		// a new nightly build.
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("ETag", `"changed"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(gzipBytes(t, makeTestTar(t, map[string]string{
+			"mongodb-linux-x86_64-4.4.2-rc0-13-gdef5678/bin/mongod": "mongod",
+		}))))
+	})
+	manifestFile := filepath.Join(t.TempDir(), "manifest.json")
+
+	results, err := Fetch(ctx, FetchOptions{
+		Releases:     []string{"4.4-latest"},
+		Path:         dir,
+		Builds:       []bond.BuildOptions{{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}},
+		ManifestFile: manifestFile,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	manifest, err := ReadManifestFile(manifestFile)
+	require.NoError(t, err)
+	require.Len(t, manifest.Builds, 1)
+	assert.NotEmpty(t, manifest.Builds[0].Sha256)
+	assert.Equal(t, "abc1234", manifest.Builds[0].GitHash)
+
+	t.Run("Reproduce", func(t *testing.T) {
+		results, err := Fetch(ctx, FetchOptions{Path: t.TempDir(), Manifest: manifest})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "abc1234", results[0].Metadata.GitHash)
+	})
+	t.Run("GitHashMismatch", func(t *testing.T) {
+		locked := &Manifest{Builds: []ManifestEntry{manifest.Builds[0]}}
+		locked.Builds[0].Sha256 = ""
+		locked.Builds[0].GitHash = "0000000"
+
+		_, err := Fetch(ctx, FetchOptions{Path: t.TempDir(), Manifest: locked})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "git hash")
+	})
+	t.Run("ChangedBuild", func(t *testing.T) {
+		atomic.StoreInt32(&changed, 1)
+
+		_, err := Fetch(ctx, FetchOptions{Path: t.TempDir(), Manifest: manifest})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrChecksumMismatch), err.Error())
+
+		// revalidating the existing copy downloads the new build.
+		_, err = Fetch(ctx, FetchOptions{Path: dir, Manifest: manifest})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrChecksumMismatch), err.Error())
+	})
+}
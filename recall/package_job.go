@@ -0,0 +1,220 @@
+package recall
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/evergreen-ci/bond"
+	"github.com/mongodb/amboy"
+	"github.com/mongodb/amboy/dependency"
+	"github.com/mongodb/amboy/job"
+	"github.com/mongodb/amboy/registry"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// DownloadPackagesJob is an amboy.Job implementation that downloads
+// the distribution packages (deb or rpm) for a build and extracts the
+// binaries they contain into a directory laid out like an extracted
+// build archive, so that the result can be used in a BuildCatalog.
+type DownloadPackagesJob struct {
+	URLs      []string `bson:"urls" json:"urls" yaml:"urls"`
+	Directory string   `bson:"dir" json:"dir" yaml:"dir"`
+	Name      string   `bson:"name" json:"name" yaml:"name"`
+	Force     bool     `bson:"force" json:"force" yaml:"force"`
+
+	// Downloaded is true if the job downloaded any packages, rather
+	// than reusing packages that were already downloaded.
+	Downloaded bool `bson:"downloaded" json:"downloaded" yaml:"downloaded"`
+	// DownloadFailed is true if the request for FailedURL failed,
+	// with StatusCode if the server responded with an error, since
+	// job errors are only recorded as strings.
+	DownloadFailed bool   `bson:"download_failed" json:"download_failed" yaml:"download_failed"`
+	FailedURL      string `bson:"failed_url,omitempty" json:"failed_url,omitempty" yaml:"failed_url,omitempty"`
+	StatusCode     int    `bson:"status_code,omitempty" json:"status_code,omitempty" yaml:"status_code,omitempty"`
+
+	*job.Base `bson:"metadata" json:"metadata" yaml:"metadata"`
+}
+
+func init() {
+	registry.AddJobType("bond-recall-download-packages", func() amboy.Job {
+		return newDownloadPackagesJob()
+	})
+}
+
+func newDownloadPackagesJob() *DownloadPackagesJob {
+	return &DownloadPackagesJob{
+		Base: &job.Base{
+			JobType: amboy.JobType{
+				Name:    "bond-recall-download-packages",
+				Version: 0,
+			},
+		},
+	}
+}
+
+// NewDownloadPackagesJob constructs a DownloadPackagesJob, which
+// downloads the packages into the "packages" directory in path and
+// extracts their binaries into the directory path/name. The job has a
+// dependency on the extracted directory, and will only execute if
+// that directory does not exist. Jobs for the same packages and
+// directory have the same ID, unless they're forced. Forced jobs
+// download the packages again even if they were already downloaded.
+func NewDownloadPackagesJob(urls []string, path, name string, force bool) (*DownloadPackagesJob, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("must specify at least one package")
+	}
+
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, errors.Errorf("'%s' is not a valid build name", name)
+	}
+
+	j := newDownloadPackagesJob()
+	for _, url := range urls {
+		if !strings.HasPrefix(url, "http") || strings.HasSuffix(url, "/") {
+			return nil, errors.Errorf("'%s' is not a valid package url", url)
+		}
+
+		switch ext := filepath.Ext(url); ext {
+		case ".deb", ".rpm":
+		default:
+			return nil, errors.Errorf("cannot extract binaries from '%s' packages (%s)", ext, url)
+		}
+	}
+
+	if stat, err := os.Stat(path); !os.IsNotExist(err) && !stat.IsDir() {
+		return nil, errors.Errorf("'%s' is not a directory, cannot download files into it", path)
+	}
+
+	j.URLs = urls
+	j.Directory = path
+	j.Name = name
+	j.Force = force
+	j.SetID(fmt.Sprintf("%s-packages-%x", name, sha1.Sum([]byte(strings.Join(urls, ",")))))
+
+	if force {
+		j.SetID(fmt.Sprintf("%s-%d", j.ID(), time.Now().UnixNano()))
+		j.SetDependency(dependency.NewAlways())
+	} else {
+		j.SetDependency(dependency.NewCreatesFile(j.getBuildDirectory()))
+	}
+
+	return j, nil
+}
+
+// Run downloads all of the packages and extracts their binaries.
+func (j *DownloadPackagesJob) Run(ctx context.Context) {
+	defer j.MarkComplete()
+
+	dir := j.getBuildDirectory()
+	if state := j.Dependency().State(); state == dependency.Passed {
+		grip.Debug(ctx, message.Fields{
+			"dir":     dir,
+			"message": "packages are already extracted",
+			"op":      "none",
+		})
+		return
+	}
+
+	var files []string
+	for _, url := range j.URLs {
+		fn := j.getPackageFileName(url)
+		files = append(files, fn)
+
+		if _, err := os.Stat(fn); err == nil && !j.Force {
+			continue
+		}
+
+		// forced downloads replace existing packages once the new
+		// copy is completely downloaded.
+		downloaded, err := bond.RefreshDownload(ctx, url, fn, true)
+		if err != nil {
+			var dl *bond.DownloadError
+			if errors.As(err, &dl) {
+				j.DownloadFailed = true
+				j.FailedURL = url
+				j.StatusCode = dl.StatusCode
+			}
+			j.AddError(errors.Wrapf(err, "downloading package '%s'", url))
+			return
+		}
+		j.Downloaded = j.Downloaded || downloaded
+	}
+
+	if err := os.MkdirAll(j.Directory, 0755); err != nil {
+		j.AddError(errors.Wrapf(err, "creating directory '%s'", j.Directory))
+		return
+	}
+
+	staging, err := ioutil.TempDir(j.Directory, ".extract-"+j.Name)
+	if err != nil {
+		j.AddError(errors.Wrap(err, "creating staging directory"))
+		return
+	}
+	defer os.RemoveAll(staging)
+
+	for _, fn := range files {
+		if err := extractPackageBinaries(fn, staging); err != nil {
+			j.AddError(err)
+			return
+		}
+	}
+
+	if err := os.Chmod(staging, 0755); err != nil {
+		j.AddError(errors.Wrapf(err, "setting permissions on '%s'", staging))
+		return
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		j.AddError(errors.Wrapf(err, "removing existing directory '%s'", dir))
+		return
+	}
+
+	if err := os.Rename(staging, dir); err != nil {
+		j.AddError(errors.Wrapf(err, "moving extracted packages to '%s'", dir))
+		return
+	}
+
+	grip.Debug(ctx, message.Fields{
+		"op":       "extracted packages",
+		"dir":      dir,
+		"packages": j.URLs,
+	})
+
+	recordBuildMetadata(ctx, dir, "")
+}
+
+func (j *DownloadPackagesJob) getBuildDirectory() string {
+	return filepath.Join(j.Directory, j.Name)
+}
+
+func (j *DownloadPackagesJob) getPackageDirectory() string {
+	return filepath.Join(j.Directory, "packages", j.Name)
+}
+
+func (j *DownloadPackagesJob) getPackageFileName(url string) string {
+	return filepath.Join(j.getPackageDirectory(), filepath.Base(url))
+}
+
+// retry creates a forced copy of the job with the given ID.
+func (j *DownloadPackagesJob) retry(id string) (amboy.Job, error) {
+	retry, err := NewDownloadPackagesJob(j.URLs, j.Directory, j.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	retry.SetID(id)
+	return retry, nil
+}
+
+// filesExist returns true if the extracted directory exists.
+func (j *DownloadPackagesJob) filesExist() bool {
+	_, err := os.Stat(j.getBuildDirectory())
+	return err == nil
+}
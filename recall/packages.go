@@ -0,0 +1,256 @@
+package recall
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	arMagic  = []byte("!<arch>\n")
+	rpmMagic = []byte{0xed, 0xab, 0xee, 0xdb}
+)
+
+const (
+	arHeaderSize   = 60
+	rpmLeadSize    = 96
+	cpioHeaderSize = 110
+	cpioTrailer    = "TRAILER!!!"
+)
+
+// extractPackageBinaries extracts the executables that a deb or rpm
+// package installs into "/usr/bin" into the "bin" directory of
+// dest. Packages are read directly, so extraction does not require
+// root access or a system package manager.
+func extractPackageBinaries(fn, dest string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return errors.Wrapf(err, "opening package '%s'", fn)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(len(arMagic))
+	if err != nil {
+		return errors.Wrapf(err, "reading header of package '%s'", fn)
+	}
+
+	switch {
+	case bytes.Equal(magic, arMagic):
+		err = extractDebBinaries(r, dest)
+	case bytes.HasPrefix(magic, rpmMagic):
+		err = extractRPMBinaries(r, dest)
+	default:
+		return errors.Errorf("file '%s' is in unsupported package format", fn)
+	}
+
+	return errors.Wrapf(err, "extracting package '%s'", fn)
+}
+
+// extractDebBinaries reads a deb package, which is an ar archive
+// containing a (compressed) tarball named "data.tar.*".
+func extractDebBinaries(r io.Reader, dest string) error {
+	if _, err := io.CopyN(io.Discard, r, int64(len(arMagic))); err != nil {
+		return errors.Wrap(err, "reading ar header")
+	}
+
+	header := make([]byte, arHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return errors.New("package does not contain a data archive")
+		} else if err != nil {
+			return errors.Wrap(err, "reading ar member header")
+		}
+
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "parsing size of ar member '%s'", name)
+		}
+
+		if strings.HasPrefix(name, "data.tar") {
+			return extractTarBinaries(io.LimitReader(r, size), dest)
+		}
+
+		// ar members are aligned to two bytes.
+		if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+			return errors.Wrapf(err, "skipping ar member '%s'", name)
+		}
+	}
+}
+
+func extractTarBinaries(r io.Reader, dest string) error {
+	dr, err := newDecompressingReader(r)
+	if err != nil {
+		return errors.Wrap(err, "decompressing data archive")
+	}
+	defer dr.Close()
+
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "reading data archive")
+		}
+
+		if header.Typeflag != tar.TypeReg || !isPackageBinary(header.Name) {
+			continue
+		}
+
+		if err := writePackageBinary(tr, dest, header.Name, header.FileInfo().Mode()); err != nil {
+			return err
+		}
+	}
+}
+
+// extractRPMBinaries reads an rpm package, which is made up of a
+// fixed-size lead, a signature header, a header, and a (compressed)
+// cpio archive payload.
+func extractRPMBinaries(r io.Reader, dest string) error {
+	if _, err := io.CopyN(io.Discard, r, rpmLeadSize); err != nil {
+		return errors.Wrap(err, "reading rpm lead")
+	}
+
+	// the signature header is padded to eight bytes, the main
+	// header is not.
+	if err := skipRPMHeader(r, true); err != nil {
+		return errors.Wrap(err, "reading rpm signature")
+	}
+	if err := skipRPMHeader(r, false); err != nil {
+		return errors.Wrap(err, "reading rpm header")
+	}
+
+	dr, err := newDecompressingReader(r)
+	if err != nil {
+		return errors.Wrap(err, "decompressing rpm payload")
+	}
+	defer dr.Close()
+
+	return extractCPIOBinaries(dr, dest)
+}
+
+func skipRPMHeader(r io.Reader, padded bool) error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return errors.Wrap(err, "reading header structure")
+	}
+
+	if !bytes.Equal(header[0:3], []byte{0x8e, 0xad, 0xe8}) {
+		return errors.New("invalid header magic")
+	}
+
+	entries := int64(binary.BigEndian.Uint32(header[8:12]))
+	size := entries*16 + int64(binary.BigEndian.Uint32(header[12:16]))
+	if padded && size%8 != 0 {
+		size += 8 - size%8
+	}
+
+	if _, err := io.CopyN(io.Discard, r, size); err != nil {
+		return errors.Wrap(err, "skipping header data")
+	}
+
+	return nil
+}
+
+// extractCPIOBinaries reads a cpio archive in the "new ASCII" format
+// used by rpm payloads.
+func extractCPIOBinaries(r io.Reader, dest string) error {
+	header := make([]byte, cpioHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return errors.Wrap(err, "reading cpio header")
+		}
+
+		if magic := string(header[0:6]); magic != "070701" && magic != "070702" {
+			return errors.Errorf("unsupported cpio format '%s'", magic)
+		}
+
+		field := func(idx int) (int64, error) {
+			return strconv.ParseInt(string(header[6+idx*8:14+idx*8]), 16, 64)
+		}
+
+		mode, err := field(1)
+		if err != nil {
+			return errors.Wrap(err, "parsing cpio file mode")
+		}
+		size, err := field(6)
+		if err != nil {
+			return errors.Wrap(err, "parsing cpio file size")
+		}
+		nameSize, err := field(11)
+		if err != nil {
+			return errors.Wrap(err, "parsing cpio name size")
+		}
+
+		// the header and name are padded to four bytes, as is the
+		// file data.
+		name := make([]byte, nameSize+pad4(cpioHeaderSize+nameSize))
+		if _, err := io.ReadFull(r, name); err != nil {
+			return errors.Wrap(err, "reading cpio file name")
+		}
+		fn := strings.TrimRight(string(name[:nameSize]), "\x00")
+
+		if fn == cpioTrailer {
+			return nil
+		}
+
+		data := &io.LimitedReader{R: r, N: size}
+		// 0100000 is the file type for regular files
+		if mode&0170000 == 0100000 && isPackageBinary(fn) {
+			if err := writePackageBinary(data, dest, fn, os.FileMode(mode&0777)); err != nil {
+				return err
+			}
+		}
+
+		// skip any of the file's data that wasn't extracted.
+		if _, err := io.CopyN(io.Discard, r, data.N+pad4(size)); err != nil {
+			return errors.Wrapf(err, "skipping cpio file '%s'", fn)
+		}
+	}
+}
+
+func pad4(n int64) int64 {
+	if n%4 == 0 {
+		return 0
+	}
+	return 4 - n%4
+}
+
+func isPackageBinary(name string) bool {
+	name = path.Clean("/" + name)
+	return path.Dir(name) == "/usr/bin"
+}
+
+func writePackageBinary(r io.Reader, dest, name string, mode os.FileMode) error {
+	bin := filepath.Join(dest, "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		return errors.Wrapf(err, "creating directory '%s'", bin)
+	}
+
+	// only the base name is used, so package entries cannot write
+	// outside of the destination.
+	fn := filepath.Join(bin, path.Base(name))
+	out, err := os.OpenFile(fn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode|0700)
+	if err != nil {
+		return errors.Wrapf(err, "creating file '%s'", fn)
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return errors.Wrapf(err, "writing file '%s'", fn)
+	}
+
+	return errors.Wrapf(out.Close(), "closing file '%s'", fn)
+}
@@ -0,0 +1,226 @@
+package recall
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+var testPackageFiles = map[string]string{
+	"./usr/bin/mongod":                "mongod binary",
+	"./usr/bin/mongos":                "mongos binary",
+	"./usr/share/doc/mongodb/LICENSE": "license",
+	"./etc/mongod.conf":               "config",
+	"./usr/bin/nested/not-a-binary":   "ignored",
+}
+
+func makeTestTar(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0755,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func makeTestDeb(t *testing.T, compress func([]byte) []byte, dataName string) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write(arMagic)
+
+	addMember := func(name string, data []byte) {
+		fmt.Fprintf(buf, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, 0, 0, 0, "100644", len(data))
+		buf.Write(data)
+		if len(data)%2 != 0 {
+			buf.WriteByte('\n')
+		}
+	}
+
+	addMember("debian-binary", []byte("2.0\n"))
+	addMember("control.tar.gz", gzipBytes(t, makeTestTar(t, map[string]string{"./control": "Package: mongodb-org-server"})))
+	addMember(dataName, compress(makeTestTar(t, testPackageFiles)))
+
+	return buf.Bytes()
+}
+
+func makeTestRPM(t *testing.T, compress func([]byte) []byte) []byte {
+	buf := &bytes.Buffer{}
+
+	lead := make([]byte, rpmLeadSize)
+	copy(lead, rpmMagic)
+	buf.Write(lead)
+
+	addHeader := func(entries, size uint32, padded bool) {
+		header := make([]byte, 16)
+		copy(header, []byte{0x8e, 0xad, 0xe8, 0x01})
+		binary.BigEndian.PutUint32(header[8:12], entries)
+		binary.BigEndian.PutUint32(header[12:16], size)
+		buf.Write(header)
+
+		total := int(entries*16 + size)
+		if padded && total%8 != 0 {
+			total += 8 - total%8
+		}
+		buf.Write(make([]byte, total))
+	}
+	addHeader(1, 5, true)
+	addHeader(2, 7, false)
+
+	cpio := &bytes.Buffer{}
+	addFile := func(name string, mode int64, data []byte) {
+		fmt.Fprintf(cpio, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			0, mode, 0, 0, 1, 0, len(data), 0, 0, 0, 0, len(name)+1, 0)
+		cpio.WriteString(name)
+		cpio.WriteByte(0)
+		cpio.Write(make([]byte, pad4(int64(cpioHeaderSize+len(name)+1))))
+		cpio.Write(data)
+		cpio.Write(make([]byte, pad4(int64(len(data)))))
+	}
+	addFile("./usr/bin", 040755, nil)
+	for name, content := range testPackageFiles {
+		addFile(name, 0100755, []byte(content))
+	}
+	addFile(cpioTrailer, 0, nil)
+
+	buf.Write(compress(cpio.Bytes()))
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func xzBytes(t *testing.T, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w, err := xz.NewWriter(buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w, err := zstd.NewWriter(buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestExtractPackageBinaries(t *testing.T) {
+	t.Parallel()
+
+	for name, pkg := range map[string]func(t *testing.T) []byte{
+		"DebWithGzip": func(t *testing.T) []byte {
+			return makeTestDeb(t, func(b []byte) []byte { return gzipBytes(t, b) }, "data.tar.gz")
+		},
+		"DebWithXz": func(t *testing.T) []byte {
+			return makeTestDeb(t, func(b []byte) []byte { return xzBytes(t, b) }, "data.tar.xz")
+		},
+		"DebWithZstd": func(t *testing.T) []byte {
+			return makeTestDeb(t, func(b []byte) []byte { return zstdBytes(t, b) }, "data.tar.zst")
+		},
+		"RPMWithGzip": func(t *testing.T) []byte {
+			return makeTestRPM(t, func(b []byte) []byte { return gzipBytes(t, b) })
+		},
+		"RPMWithXz": func(t *testing.T) []byte {
+			return makeTestRPM(t, func(b []byte) []byte { return xzBytes(t, b) })
+		},
+		"RPMWithZstd": func(t *testing.T) []byte {
+			return makeTestRPM(t, func(b []byte) []byte { return zstdBytes(t, b) })
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			fn := filepath.Join(dir, "package")
+			require.NoError(t, ioutil.WriteFile(fn, pkg(t), 0644))
+
+			dest := filepath.Join(dir, "build")
+			require.NoError(t, extractPackageBinaries(fn, dest))
+
+			contents, err := ioutil.ReadDir(filepath.Join(dest, "bin"))
+			require.NoError(t, err)
+			require.Len(t, contents, 2)
+			for _, bin := range []string{"mongod", "mongos"} {
+				data, err := ioutil.ReadFile(filepath.Join(dest, "bin", bin))
+				assert.NoError(t, err)
+				assert.Equal(t, bin+" binary", string(data))
+			}
+		})
+	}
+}
+
+func TestExtractPackageBinariesRejectsInvalidPackages(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	fn := filepath.Join(dir, "package.msi")
+	require.NoError(t, ioutil.WriteFile(fn, []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}, 0644))
+	assert.Error(t, extractPackageBinaries(fn, dir))
+
+	fn = filepath.Join(dir, "truncated.deb")
+	require.NoError(t, ioutil.WriteFile(fn, arMagic, 0644))
+	assert.Error(t, extractPackageBinaries(fn, dir))
+
+	assert.Error(t, extractPackageBinaries(filepath.Join(dir, "DOES-NOT-EXIST"), dir))
+	_, err := os.Stat(filepath.Join(dir, "bin"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadPackagesJobConstructor(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	urls := []string{
+		"https://repo.mongodb.org/apt/ubuntu/dists/focal/mongodb-org/4.4/multiverse/binary-amd64/mongodb-org-server_4.4.1_amd64.deb",
+		"https://repo.mongodb.org/apt/ubuntu/dists/focal/mongodb-org/4.4/multiverse/binary-amd64/mongodb-org-mongos_4.4.1_amd64.deb",
+	}
+
+	j, err := NewDownloadPackagesJob(urls, dir, "mongodb-linux-x86_64-ubuntu2004-4.4.1", false)
+	assert.NoError(err)
+	assert.NotNil(j)
+	assert.Equal(urls, j.URLs)
+	assert.Equal(filepath.Join(dir, "mongodb-linux-x86_64-ubuntu2004-4.4.1"), j.getBuildDirectory())
+
+	for _, args := range []struct {
+		urls []string
+		name string
+	}{
+		{urls: nil, name: "mongodb-linux-x86_64-ubuntu2004-4.4.1"},
+		{urls: urls, name: ""},
+		{urls: urls, name: "../mongodb"},
+		{urls: []string{"https://downloads.mongodb.com/windows/mongodb-windows-x86_64-enterprise-4.4.1-signed.msi"}, name: "mongodb"},
+		{urls: []string{"ftp://example.net/mongodb-org-server_4.4.1_amd64.deb"}, name: "mongodb"},
+	} {
+		j, err = NewDownloadPackagesJob(args.urls, dir, args.name, false)
+		assert.Error(err)
+		assert.Nil(j)
+	}
+}
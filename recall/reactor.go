@@ -6,8 +6,8 @@ import (
 
 	"github.com/evergreen-ci/bond"
 	"github.com/mongodb/amboy"
-	"github.com/mongodb/amboy/queue"
 	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
 	"github.com/pkg/errors"
 )
 
@@ -20,56 +20,131 @@ func DownloadReleases(releases []string, path string, options bond.BuildOptions)
 	return FetchReleases(context.Background(), releases, path, options)
 }
 
-// FetchReleases has the same behavior as DownloadReleases, but takes
-// a Context to facilitate caller implemented timeouts and cancelation.
-func FetchReleases(ctx context.Context, releases []string, path string, options bond.BuildOptions) error {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// DownloadLimits configures the resources that a fetch uses. The
+// zero value uses 4 workers, without limiting bandwidth or
+// connections.
+type DownloadLimits struct {
+	// Workers is the number of concurrent download jobs.
+	Workers int `bson:"workers" json:"workers" yaml:"workers"`
+	// BytesPerSecond limits the combined rate of all downloads.
+	BytesPerSecond int64 `bson:"bytes_per_second" json:"bytes_per_second" yaml:"bytes_per_second"`
+	// ConnectionsPerHost limits the concurrent downloads from each
+	// host.
+	ConnectionsPerHost int `bson:"connections_per_host" json:"connections_per_host" yaml:"connections_per_host"`
+}
 
-	if err := options.Validate(); err != nil {
-		return errors.Wrap(err, "invalid build options")
+// Validate checks that the limits are not negative.
+func (l DownloadLimits) Validate() error {
+	catcher := grip.NewCatcher()
+	catcher.NewWhen(l.Workers < 0, "workers must not be negative")
+	catcher.NewWhen(l.BytesPerSecond < 0, "bytes per second must not be negative")
+	catcher.NewWhen(l.ConnectionsPerHost < 0, "connections per host must not be negative")
+	return bond.ResolveInvalidOptions(catcher)
+}
+
+func (l DownloadLimits) workers() int {
+	if l.Workers == 0 {
+		return 4
 	}
+	return l.Workers
+}
 
-	feed, err := bond.GetArtifactsFeed(ctx, path)
-	if err != nil {
-		return errors.Wrap(err, "generating data feed")
+// apply returns a context that enforces the bandwidth and connection
+// limits.
+func (l DownloadLimits) apply(ctx context.Context) (context.Context, error) {
+	if l.BytesPerSecond > 0 {
+		limiter, err := bond.NewBandwidthLimiter(l.BytesPerSecond)
+		if err != nil {
+			return nil, err
+		}
+		ctx = bond.WithBandwidthLimiter(ctx, limiter)
 	}
 
-	q := queue.NewLocalLimitedSize(4, 1048)
-	if err := q.Start(ctx); err != nil {
-		return errors.Wrap(err, "starting queue")
+	if l.ConnectionsPerHost > 0 {
+		limiter, err := bond.NewHostLimiter(l.ConnectionsPerHost)
+		if err != nil {
+			return nil, err
+		}
+		ctx = bond.WithHostLimiter(ctx, limiter)
 	}
 
-	urls, errGroupOne := feed.GetArchives(releases, options)
-	jobs, errGroupTwo := createJobs(path, urls)
+	return ctx, nil
+}
 
-	if err := amboy.PopulateQueue(ctx, q, jobs); err != nil {
-		return errors.Wrap(err, "adding jobs to queue")
-	}
+// FetchReleases has the same behavior as DownloadReleases, but takes
+// a Context to facilitate caller implemented timeouts and cancelation.
+// The feed and the archives are downloaded with the context's HTTP
+// client, if it has one (see bond.WithHTTPClient).
+//
+// In offline mode, FetchReleases uses only the cached feed and the
+// archives that are already downloaded, and returns an error naming
+// every archive that it would have downloaded.
+func FetchReleases(ctx context.Context, releases []string, path string, options bond.BuildOptions) error {
+	return FetchReleasesWithLimits(ctx, releases, path, options, DownloadLimits{})
+}
 
-	if err := aggregateErrors(errGroupOne, errGroupTwo); err != nil {
-		return errors.Wrap(err, "populating jobs")
-	}
+// FetchReleasesWithLimits has the same behavior as FetchReleases, but
+// limits the workers, bandwidth and connections that the downloads
+// use.
+func FetchReleasesWithLimits(ctx context.Context, releases []string, path string, options bond.BuildOptions, limits DownloadLimits) error {
+	_, err := Fetch(ctx, FetchOptions{
+		Releases: releases,
+		Path:     path,
+		Builds:   []bond.BuildOptions{options},
+		Limits:   limits,
+	})
+	return err
+}
 
-	grip.Debugf(ctx, "waiting for %d download jobs to complete", q.Stats(ctx).Total)
-	amboy.WaitInterval(ctx, q, 100*time.Millisecond)
-	grip.Debug(ctx, "all download tasks complete, processing errors now")
+// FetchReleasePackages has the same behavior as FetchReleases, but
+// downloads the distribution packages (deb or rpm) for each release
+// rather than the archive, and extracts the binaries from the
+// packages into a directory with the same name as the archive would
+// have, so that the result can be used in a BuildCatalog.
+func FetchReleasePackages(ctx context.Context, releases []string, path string, options bond.BuildOptions) error {
+	_, err := Fetch(ctx, FetchOptions{
+		Releases: releases,
+		Path:     path,
+		Builds:   []bond.BuildOptions{options},
+		Packages: true,
+	})
+	return err
+}
 
-	if err := amboy.ResolveErrors(ctx, q); err != nil {
-		return errors.Wrap(err, "resolving download job errors")
+// progressLogInterval is the interval between the logged summaries of
+// the progress of all of the downloads in a fetch.
+const progressLogInterval = 10 * time.Second
+
+func logProgress(ctx context.Context, q amboy.Queue, progress *bond.ProgressAggregator) {
+	summary := progress.Summary()
+	stats := q.Stats(ctx)
+
+	msg := message.Fields{
+		"message":   "download progress",
+		"jobs":      stats.Total,
+		"completed": stats.Completed,
+		"downloads": summary.Downloads,
+		"received":  summary.Received,
+		"rate":      int64(summary.Rate),
+	}
+	if summary.Total >= 0 {
+		msg["total"] = summary.Total
+	}
+	if summary.ETA > 0 {
+		msg["eta"] = summary.ETA.Round(time.Second).String()
 	}
 
-	return nil
+	grip.Info(ctx, msg)
 }
 
-func createJobs(path string, urls <-chan string) (<-chan amboy.Job, <-chan error) {
-	output := make(chan amboy.Job)
+func createJobs(path string, force bool, urls <-chan string) (<-chan *DownloadFileJob, <-chan error) {
+	output := make(chan *DownloadFileJob)
 	errOut := make(chan error)
 
 	go func() {
 		catcher := grip.NewCatcher()
 		for url := range urls {
-			j, err := NewDownloadJob(url, path, false)
+			j, err := NewDownloadJob(url, path, force)
 			if err != nil {
 				catcher.Add(errors.Wrapf(err,
 					"problem generating task for %s", url))
@@ -80,7 +155,7 @@ func createJobs(path string, urls <-chan string) (<-chan amboy.Job, <-chan error
 		}
 		close(output)
 		if catcher.HasErrors() {
-			errOut <- catcher.Resolve()
+			errOut <- bond.ResolveErrors(catcher)
 		}
 		close(errOut)
 	}()
@@ -97,5 +172,5 @@ func aggregateErrors(groups ...<-chan error) error {
 		}
 	}
 
-	return catcher.Resolve()
+	return bond.ResolveErrors(catcher)
 }
@@ -1,6 +1,7 @@
 package recall
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
@@ -45,7 +46,7 @@ func (s *ReactorSuite) TestJobCreator() {
 	urls <- "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-2.8.10.tgz"
 	close(urls)
 
-	jobs, errs := createJobs(s.tempDir, urls)
+	jobs, errs := createJobs(s.tempDir, false, urls)
 
 	done := make(chan struct{})
 	go func() {
@@ -69,7 +70,7 @@ func (s *ReactorSuite) TestCreateJobsErrorsWithInvalidPath() {
 	close(urls)
 	fn := filepath.Join(s.tempDir, "foo")
 	s.NoError(ioutil.WriteFile(fn, []byte("hello"), 0644))
-	_, errs := createJobs(fn, urls)
+	_, errs := createJobs(fn, false, urls)
 
 	s.Error(aggregateErrors(errs))
 }
@@ -173,3 +174,77 @@ func TestErrorAggregator(t *testing.T) {
 
 	assert.Error(aggregateErrors(chans...))
 }
+
+const testOfflineFeedData = `{
+  "versions": [
+    {
+      "version": "4.4.1",
+      "downloads": [
+        {
+          "arch": "x86_64",
+          "edition": "base",
+          "target": "linux_x86_64",
+          "archive": {"url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.1.tgz"}
+        }
+      ]
+    },
+    {
+      "version": "4.4.0",
+      "downloads": [
+        {
+          "arch": "x86_64",
+          "edition": "base",
+          "target": "linux_x86_64",
+          "archive": {"url": "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.0.tgz"}
+        }
+      ]
+    }
+  ]
+}`
+
+func TestFetchReleasesOffline(t *testing.T) {
+	t.Parallel()
+	ctx := bond.WithOffline(context.Background())
+	opts := bond.BuildOptions{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}
+	dir := t.TempDir()
+
+	// without a cached feed, nothing can be fetched.
+	err := FetchReleases(ctx, []string{"4.4.1"}, dir, opts)
+	require.Error(t, err)
+	assert.True(t, bond.IsOfflineError(err))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "full.json"), []byte(testOfflineFeedData), 0644))
+	archive := gzipBytes(t, makeTestTar(t, map[string]string{
+		"mongodb-linux-x86_64-4.4.1/bin/mongod": "mongod",
+	}))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "mongodb-linux-x86_64-4.4.1.tgz"), archive, 0644))
+
+	// cached archives are extracted.
+	require.NoError(t, FetchReleases(ctx, []string{"4.4.1"}, dir, opts))
+	_, err = os.Stat(filepath.Join(dir, "mongodb-linux-x86_64-4.4.1", "bin", "mongod"))
+	assert.NoError(t, err)
+
+	// missing archives are named in the error.
+	err = FetchReleases(ctx, []string{"4.4.1", "4.4.0"}, dir, opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "https://fastdl.mongodb.org/linux/mongodb-linux-x86_64-4.4.0.tgz")
+	assert.NotContains(t, err.Error(), "4.4.1")
+}
+
+func TestDownloadLimits(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, DownloadLimits{}.Validate())
+	assert.Equal(t, 4, DownloadLimits{}.workers())
+	assert.Equal(t, 8, DownloadLimits{Workers: 8}.workers())
+
+	for _, limits := range []DownloadLimits{
+		{Workers: -1},
+		{BytesPerSecond: -1},
+		{ConnectionsPerHost: -1},
+	} {
+		assert.Error(t, limits.Validate())
+		assert.Error(t, FetchReleasesWithLimits(context.Background(), []string{"4.4.1"}, t.TempDir(),
+			bond.BuildOptions{Target: "linux_x86_64", Arch: bond.AMD64, Edition: bond.Base}, limits))
+	}
+}
@@ -0,0 +1,142 @@
+package bond
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// StartRefresh populates the feed every interval, in a background
+// goroutine, until ctx is canceled. The cached copy of the feed is
+// downloaded again when it is older than the interval. Each refresh
+// replaces the feed's data atomically, and notifies the feed's hooks
+// and subscribers. Refresh errors are logged, and leave the current
+// data in place.
+//
+// A feed can only have one background refresh at a time.
+func (feed *ArtifactsFeed) StartRefresh(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.Errorf("refresh interval must be positive, not %s", interval)
+	}
+
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	if feed.refreshing {
+		return errors.New("feed is already refreshing")
+	}
+	feed.refreshing = true
+
+	go func() {
+		defer func() {
+			feed.mutex.Lock()
+			feed.refreshing = false
+			feed.mutex.Unlock()
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				grip.Warning(ctx, message.WrapError(feed.Populate(ctx, interval), message.Fields{
+					"message":  "could not refresh feed",
+					"path":     feed.path,
+					"interval": interval.String(),
+				}))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// OnChange registers a callback that is called after Populate when the
+// feed's contents have changed.
+func (feed *ArtifactsFeed) OnChange(callback FeedHook) {
+	feed.AddHook(func(ctx context.Context, diff *FeedDiff) {
+		if !diff.IsEmpty() {
+			callback(ctx, diff)
+		}
+	})
+}
+
+// subscriberBufferSize is the number of changes that a subscriber's
+// channel holds before the oldest changes are dropped.
+const subscriberBufferSize = 16
+
+// Subscribe returns a channel that receives the changes to the feed
+// each time Populate changes its contents. The channel is closed when
+// ctx is canceled. Populate doesn't wait for subscribers: the channel
+// buffers the most recent changes, and subscribers that fall behind
+// miss the oldest ones.
+func (feed *ArtifactsFeed) Subscribe(ctx context.Context) <-chan *FeedDiff {
+	sub := &feedSubscriber{
+		ch: make(chan *FeedDiff, subscriberBufferSize),
+	}
+
+	feed.mutex.Lock()
+	feed.subscribers = append(feed.subscribers, sub)
+	feed.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		feed.mutex.Lock()
+		for idx, s := range feed.subscribers {
+			if s == sub {
+				feed.subscribers = append(feed.subscribers[:idx], feed.subscribers[idx+1:]...)
+				break
+			}
+		}
+		feed.mutex.Unlock()
+
+		sub.close()
+	}()
+
+	return sub.ch
+}
+
+type feedSubscriber struct {
+	ch     chan *FeedDiff
+	mu     sync.Mutex
+	closed bool
+}
+
+// send adds the change to the subscriber's channel without blocking,
+// dropping the oldest change if the channel is full.
+func (s *feedSubscriber) send(diff *FeedDiff) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	for {
+		select {
+		case s.ch <- diff:
+			return
+		default:
+		}
+
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+}
+
+func (s *feedSubscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	close(s.ch)
+}
@@ -0,0 +1,121 @@
+package bond
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedStartRefresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu   sync.Mutex
+		data = testFeedData
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write([]byte(data))
+	}))
+	defer srv.Close()
+
+	feed, err := NewArtifactsFeed(t.TempDir())
+	require.NoError(t, err)
+	feed.url = srv.URL
+	require.NoError(t, feed.Populate(ctx, time.Hour))
+
+	changes := feed.Subscribe(ctx)
+	var (
+		callbackMu sync.Mutex
+		callbacks  []*FeedDiff
+	)
+	feed.OnChange(func(_ context.Context, diff *FeedDiff) {
+		callbackMu.Lock()
+		defer callbackMu.Unlock()
+		callbacks = append(callbacks, diff)
+	})
+
+	assert.Error(t, feed.StartRefresh(ctx, 0))
+	require.NoError(t, feed.StartRefresh(ctx, 10*time.Millisecond))
+	assert.Error(t, feed.StartRefresh(ctx, 10*time.Millisecond), "only one refresh can run at a time")
+
+	// refreshes that don't change the feed don't notify subscribers.
+	select {
+	case diff := <-changes:
+		t.Fatalf("unexpected change %+v", diff)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	mu.Lock()
+	data = updatedTestFeedData
+	mu.Unlock()
+
+	select {
+	case diff := <-changes:
+		assert.Equal(t, []string{"4.4.2"}, diff.AddedVersions)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for feed to change")
+	}
+
+	_, ok := feed.GetVersion("4.4.2")
+	assert.True(t, ok)
+
+	callbackMu.Lock()
+	assert.Len(t, callbacks, 1)
+	callbackMu.Unlock()
+
+	cancel()
+	for range changes {
+		// the channel is closed when the context is canceled.
+	}
+
+	// the refresh can be restarted once the previous one stops.
+	assert.Eventually(t, func() bool {
+		refreshCtx, refreshCancel := context.WithCancel(context.Background())
+		defer refreshCancel()
+		return feed.StartRefresh(refreshCtx, time.Hour) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFeedSubscribeDoesNotBlock(t *testing.T) {
+	feed := newTestFeed(t)
+	changes := feed.Subscribe(context.Background())
+
+	// the subscriber never reads, so only the most recent changes
+	// are kept.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for idx := 0; idx < 2*subscriberBufferSize+1; idx++ {
+			data := updatedTestFeedData
+			if idx%2 == 1 {
+				data = testFeedData
+			}
+
+			old := summarizeFeed(feed)
+			assert.NoError(t, feed.Reload([]byte(data)))
+			feed.runHooks(context.Background(), old)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("updating the feed blocked on the subscriber")
+	}
+
+	require.Len(t, changes, subscriberBufferSize)
+	var last *FeedDiff
+	for idx := 0; idx < subscriberBufferSize; idx++ {
+		last = <-changes
+	}
+	assert.Equal(t, []string{"4.4.2"}, last.AddedVersions)
+}
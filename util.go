@@ -1,11 +1,17 @@
 package bond
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 const httpClientTimeout = 10 * time.Minute
@@ -40,3 +46,167 @@ func GetHTTPClient() *http.Client { return httpClientPool.Get().(*http.Client) }
 func PutHTTPClient(c *http.Client) {
 	httpClientPool.Put(c)
 }
+
+// HTTPClientOptions configures the HTTP clients that NewHTTPClient
+// creates. The zero value produces a client equivalent to the
+// clients that GetHTTPClient returns.
+type HTTPClientOptions struct {
+	// Timeout limits the duration of each request, including
+	// reading the response body. Defaults to 10 minutes.
+	Timeout time.Duration `bson:"timeout" json:"timeout" yaml:"timeout"`
+	// Proxy is the URL of the proxy for all requests. By default,
+	// proxies are read from the environment.
+	Proxy string `bson:"proxy" json:"proxy" yaml:"proxy"`
+	// RootCAFiles are PEM files of certificate authorities that
+	// are trusted in addition to RootCAs.
+	RootCAFiles []string `bson:"root_ca_files" json:"root_ca_files" yaml:"root_ca_files"`
+	// RootCAs replaces the system's certificate authorities.
+	RootCAs *x509.CertPool `bson:"-" json:"-" yaml:"-"`
+	// CertFile and KeyFile are the PEM files of a client
+	// certificate for servers that require one.
+	CertFile string `bson:"cert_file" json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `bson:"key_file" json:"key_file" yaml:"key_file"`
+	// Certificates are additional client certificates.
+	Certificates []tls.Certificate `bson:"-" json:"-" yaml:"-"`
+	// UserAgent, if set, replaces the User-Agent header of every
+	// request.
+	UserAgent string `bson:"user_agent" json:"user_agent" yaml:"user_agent"`
+	// Headers are added to every request, for example to
+	// authenticate with a mirror, except redirects to other hosts.
+	Headers http.Header `bson:"headers" json:"headers" yaml:"headers"`
+	// KeepAlives reuses connections between requests.
+	KeepAlives bool `bson:"keep_alives" json:"keep_alives" yaml:"keep_alives"`
+}
+
+// NewHTTPClient creates an HTTP client with the specified options.
+func NewHTTPClient(opts HTTPClientOptions) (*http.Client, error) {
+	if opts.Timeout < 0 {
+		return nil, errors.Errorf("timeout must not be negative, not %s", opts.Timeout)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = httpClientTimeout
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing proxy URL '%s'", opts.Proxy)
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, errors.Errorf("proxy URL '%s' must have a scheme and host", opts.Proxy)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConf := &tls.Config{
+		RootCAs:      opts.RootCAs,
+		Certificates: append([]tls.Certificate{}, opts.Certificates...),
+	}
+
+	if len(opts.RootCAFiles) > 0 {
+		if tlsConf.RootCAs == nil {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			tlsConf.RootCAs = pool
+		} else {
+			tlsConf.RootCAs = tlsConf.RootCAs.Clone()
+		}
+
+		for _, fn := range opts.RootCAFiles {
+			data, err := ioutil.ReadFile(fn)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading certificate authority file '%s'", fn)
+			}
+			if !tlsConf.RootCAs.AppendCertsFromPEM(data) {
+				return nil, errors.Errorf("no certificates found in '%s'", fn)
+			}
+		}
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client certificate")
+		}
+		tlsConf.Certificates = append(tlsConf.Certificates, cert)
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		TLSClientConfig:     tlsConf,
+		Proxy:               proxy,
+		DisableKeepAlives:   !opts.KeepAlives,
+		IdleConnTimeout:     20 * time.Second,
+		MaxIdleConnsPerHost: 10,
+		MaxIdleConns:        50,
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
+	if opts.UserAgent != "" || len(opts.Headers) > 0 {
+		transport = &headerTransport{
+			base:      transport,
+			userAgent: opts.UserAgent,
+			headers:   opts.Headers.Clone(),
+		}
+	}
+
+	return &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: transport,
+	}, nil
+}
+
+// headerTransport adds headers to every request before sending it.
+// Like the headers of the original request, the headers are not added
+// to redirects to other hosts, so that credentials for one host are
+// not sent to another.
+type headerTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	headers   http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := req
+	for origin.Response != nil && origin.Response.Request != nil {
+		origin = origin.Response.Request
+	}
+
+	req = req.Clone(req.Context())
+	if origin.URL.Host == req.URL.Host {
+		for key, values := range t.headers {
+			req.Header[http.CanonicalHeaderKey(key)] = append([]string{}, values...)
+		}
+	}
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+type httpClientContextKey struct{}
+
+// WithHTTPClient returns a context that makes the operations that use
+// it, such as populating feeds, DownloadFile and the recall jobs,
+// use the client rather than a default client.
+func WithHTTPClient(ctx context.Context, client *http.Client) context.Context {
+	return context.WithValue(ctx, httpClientContextKey{}, client)
+}
+
+// getContextHTTPClient returns the client from the context, or a
+// client from the pool. The release function must be called once the
+// client is no longer in use.
+func getContextHTTPClient(ctx context.Context) (client *http.Client, release func()) {
+	if client, ok := ctx.Value(httpClientContextKey{}).(*http.Client); ok && client != nil {
+		return client, func() {}
+	}
+
+	client = GetHTTPClient()
+	return client, func() { PutHTTPClient(client) }
+}
@@ -0,0 +1,130 @@
+package bond
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(r.UserAgent()))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0644))
+
+	t.Run("Defaults", func(t *testing.T) {
+		client, err := NewHTTPClient(HTTPClientOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, httpClientTimeout, client.Timeout)
+
+		// the test server's certificate isn't trusted.
+		_, err = client.Get(srv.URL)
+		assert.Error(t, err)
+	})
+	t.Run("CustomRootCAAndHeaders", func(t *testing.T) {
+		client, err := NewHTTPClient(HTTPClientOptions{
+			Timeout:     time.Minute,
+			RootCAFiles: []string{caFile},
+			UserAgent:   "bond-test",
+			Headers:     http.Header{"authorization": []string{"Bearer token"}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, time.Minute, client.Timeout)
+
+		fn := filepath.Join(t.TempDir(), "out")
+		require.NoError(t, DownloadFile(WithHTTPClient(context.Background(), client), srv.URL+"/file", fn))
+		data, err := ioutil.ReadFile(fn)
+		require.NoError(t, err)
+		assert.Equal(t, "bond-test", string(data))
+	})
+	t.Run("HeadersNotSentToRedirectHost", func(t *testing.T) {
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Header.Get("Authorization") + ";" + r.UserAgent()))
+		}))
+		defer other.Close()
+		redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/same" {
+				http.Redirect(w, r, "/other", http.StatusFound)
+				return
+			}
+			if r.URL.Path == "/other" {
+				_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+				return
+			}
+			http.Redirect(w, r, other.URL+"/file", http.StatusFound)
+		}))
+		defer redirect.Close()
+
+		client, err := NewHTTPClient(HTTPClientOptions{
+			UserAgent: "bond-test",
+			Headers:   http.Header{"Authorization": []string{"Bearer token"}},
+		})
+		require.NoError(t, err)
+		ctx := WithHTTPClient(context.Background(), client)
+
+		fn := filepath.Join(t.TempDir(), "out")
+		require.NoError(t, DownloadFile(ctx, redirect.URL+"/file", fn))
+		data, err := ioutil.ReadFile(fn)
+		require.NoError(t, err)
+		assert.Equal(t, ";bond-test", string(data))
+
+		fn = filepath.Join(t.TempDir(), "out")
+		require.NoError(t, DownloadFile(ctx, redirect.URL+"/same", fn))
+		data, err = ioutil.ReadFile(fn)
+		require.NoError(t, err)
+		assert.Equal(t, "Bearer token", string(data))
+	})
+	t.Run("InvalidOptions", func(t *testing.T) {
+		for name, opts := range map[string]HTTPClientOptions{
+			"NegativeTimeout": {Timeout: -time.Second},
+			"InvalidProxy":    {Proxy: "localhost"},
+			"MissingCAFile":   {RootCAFiles: []string{filepath.Join(t.TempDir(), "missing.pem")}},
+			"InvalidCAFile":   {RootCAFiles: []string{filepath.Join("testdata", "distro", "rhel8", "etc", "os-release")}},
+			"MissingKeyFile":  {CertFile: caFile},
+		} {
+			_, err := NewHTTPClient(opts)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestFeedPopulateUsesContextHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Mirror-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(testFeedData))
+	}))
+	defer srv.Close()
+
+	feed, err := NewArtifactsFeed(t.TempDir())
+	require.NoError(t, err)
+	feed.url = srv.URL
+
+	assert.Error(t, feed.Populate(context.Background(), time.Hour))
+
+	client, err := NewHTTPClient(HTTPClientOptions{Headers: http.Header{"X-Mirror-Token": []string{"secret"}}})
+	require.NoError(t, err)
+	require.NoError(t, feed.Populate(WithHTTPClient(context.Background(), client), time.Hour))
+	_, ok := feed.GetVersion("4.4.1")
+	assert.True(t, ok)
+}
@@ -49,7 +49,7 @@ func (version *ArtifactVersion) isDevelopmentSeries() (bool, error) {
 // given a BuildOptions object.
 func (version *ArtifactVersion) GetDownload(key BuildOptions) (ArtifactDownload, error) {
 	version.mutex.RLock()
-	defer version.mutex.RLock()
+	defer version.mutex.RUnlock()
 
 	// TODO: this is the place to fix handling for the Base edition, which is not necessarily intuitive.
 	if key.Edition == Base {
@@ -80,7 +80,7 @@ func (version *ArtifactVersion) GetDownload(key BuildOptions) (ArtifactDownload,
 
 	dl, ok := version.table[key]
 	if !ok {
-		return ArtifactDownload{}, errors.Errorf("there is no build for '%s' ('%s') in edition '%s'", key.Target, key.Arch, key.Edition)
+		return ArtifactDownload{}, errors.Wrapf(ErrBuildNotFound, "there is no build for '%s' ('%s') in edition '%s'", key.Target, key.Arch, key.Edition)
 	}
 
 	return dl, nil